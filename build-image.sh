@@ -5,7 +5,10 @@ set -eo pipefail
 docker_image_name="${1:-"malston/pks-monitor"}"
 docker_image_tag="${2:-"1.1.0"}"
 
-docker build -t "${docker_image_name}:${docker_image_tag}" .
+docker build -t "${docker_image_name}:${docker_image_tag}" \
+  --build-arg VERSION="${docker_image_tag}" \
+  --build-arg COMMIT="$(git rev-parse --short HEAD)" \
+  .
 
 docker login
 docker tag "${docker_image_name}:${docker_image_tag}" "${docker_image_name}:${docker_image_tag}"
@@ -0,0 +1,358 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pksNet "github.com/pupimvictor/pks-monitor/net"
+)
+
+// DefaultHistorySize is the number of results kept per check by default.
+const DefaultHistorySize = 20
+
+// Check is a probe the monitor runs on every interval.
+type Check interface {
+	// Name identifies the check in metrics and logs.
+	Name() string
+	// Run executes the probe once. It must not block past ctx's deadline.
+	Run(ctx context.Context) Result
+}
+
+// isolatable is implemented by the checks updating the metrics or the state of
+// their foundation when run. A probe runs the isolated copy they return instead,
+// which only returns its result.
+type isolatable interface {
+	isolated() Check
+}
+
+// Result is the outcome of a single Check run.
+type Result struct {
+	Foundation string
+	Check      string
+	Up         bool
+	Duration   time.Duration
+	Timestamp  time.Time
+	// StatusCode is the http status code the check failed on, if any.
+	StatusCode int
+	// Reason classifies why the check failed, see FailureReason.
+	Reason string
+	Err    error
+}
+
+// Registry holds the checks run by the scheduler against a foundation.
+type Registry struct {
+	mu         sync.RWMutex
+	foundation string
+	checks     []Check
+	last       map[string]Result
+	history    map[string]*resultRing
+	metrics    *metrics
+	// timeout bounds every check run, when positive
+	timeout time.Duration
+	// historySize is the number of results kept per check
+	historySize int
+
+	// runMu guards the tracking of the running checks, which draining waits for
+	runMu    sync.Mutex
+	running  int
+	idle     chan struct{}
+	draining bool
+	// abort is closed to cancel the running checks
+	abort     chan struct{}
+	abortOnce sync.Once
+}
+
+// newRegistry returns the registry of the checks of foundation, keeping the last
+// historySize results of every check, DefaultHistorySize when not positive.
+func newRegistry(foundation string, m *metrics, timeout time.Duration, historySize int) *Registry {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Registry{
+		foundation:  foundation,
+		last:        map[string]Result{},
+		history:     map[string]*resultRing{},
+		metrics:     m,
+		timeout:     timeout,
+		historySize: historySize,
+		abort:       make(chan struct{}),
+	}
+}
+
+// Register adds checks to the registry. Check names must be unique.
+func (r *Registry) Register(checks ...Check) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range checks {
+		for _, existing := range r.checks {
+			if existing.Name() == c.Name() {
+				return fmt.Errorf("pks-monitor: check %q already registered", c.Name())
+			}
+		}
+		r.checks = append(r.checks, c)
+	}
+	return nil
+}
+
+// Checks returns the registered checks in registration order.
+func (r *Registry) Checks() []Check {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	checks := make([]Check, len(r.checks))
+	copy(checks, r.checks)
+	return checks
+}
+
+// Has returns true when a check named name is registered.
+func (r *Registry) Has(name string) bool {
+	for _, c := range r.Checks() {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Last returns the last result of every check that ran, in registration order.
+func (r *Registry) Last() []Result {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var results []Result
+	for _, c := range r.checks {
+		if res, ok := r.last[c.Name()]; ok {
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// History returns the last results of the check named name, newest first.
+func (r *Registry) History(name string) []Result {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ring, ok := r.history[name]
+	if !ok {
+		return nil
+	}
+	return ring.list()
+}
+
+// record keeps res as the last result of its check and in the check's history.
+func (r *Registry) record(res Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last[res.Check] = res
+
+	ring, ok := r.history[res.Check]
+	if !ok {
+		ring = newResultRing(r.historySize)
+		r.history[res.Check] = ring
+	}
+	ring.add(res)
+}
+
+// Run runs every registered check once and records its metrics. No check runs
+// once the registry drains.
+func (r *Registry) Run(ctx context.Context) []Result {
+	ctx, done, ok := r.begin(ctx)
+	if !ok {
+		return nil
+	}
+	defer done()
+
+	var results []Result
+	for _, c := range r.Checks() {
+		results = append(results, r.run(ctx, c))
+	}
+	return results
+}
+
+// RunCheck runs the check named name once and records its metrics. It returns
+// false when no such check is registered, or when the registry drains.
+func (r *Registry) RunCheck(ctx context.Context, name string) (Result, bool) {
+	ctx, done, ok := r.begin(ctx)
+	if !ok {
+		return Result{}, false
+	}
+	defer done()
+
+	for _, c := range r.Checks() {
+		if c.Name() == name {
+			return r.run(ctx, c), true
+		}
+	}
+	return Result{}, false
+}
+
+// Probe runs the check named name once, or every check when name is empty, in
+// isolation: the results are returned only, neither observed nor recorded, and
+// the checks don't touch the state of the foundation. No check runs once the
+// registry drains.
+func (r *Registry) Probe(ctx context.Context, name string) []Result {
+	ctx, done, ok := r.begin(ctx)
+	if !ok {
+		return nil
+	}
+	defer done()
+
+	var results []Result
+	for _, c := range r.Checks() {
+		if name != "" && c.Name() != name {
+			continue
+		}
+		if i, ok := c.(isolatable); ok {
+			c = i.isolated()
+		}
+		results = append(results, r.exec(ctx, c))
+	}
+	return results
+}
+
+// begin tracks a run of the checks until done is called. The returned context
+// is cancelled by abortChecks. It returns false once the registry drains.
+func (r *Registry) begin(ctx context.Context) (context.Context, func(), bool) {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+	if r.draining {
+		return ctx, nil, false
+	}
+	if r.running == 0 {
+		r.idle = make(chan struct{})
+	}
+	r.running++
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-r.abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	done := func() {
+		cancel()
+		r.runMu.Lock()
+		defer r.runMu.Unlock()
+		r.running--
+		if r.running == 0 {
+			close(r.idle)
+		}
+	}
+	return ctx, done, true
+}
+
+// drain stops running checks and returns a channel closed once the running ones
+// finished, whether they were scheduled, run on scrape or probed.
+func (r *Registry) drain() <-chan struct{} {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+	r.draining = true
+	if r.running == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	return r.idle
+}
+
+// abortChecks cancels the running checks.
+func (r *Registry) abortChecks() {
+	r.abortOnce.Do(func() { close(r.abort) })
+}
+
+// run runs c once, then observes and records its result.
+func (r *Registry) run(ctx context.Context, c Check) Result {
+	res := r.exec(ctx, c)
+	r.metrics.observe(res)
+	r.record(res)
+	return res
+}
+
+// exec runs c once, bounded by the registry timeout.
+func (r *Registry) exec(ctx context.Context, c Check) Result {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	res := c.Run(ctx)
+	if res.Timestamp.IsZero() {
+		res.Timestamp = start
+	}
+	res.Foundation = r.foundation
+	res.Check = c.Name()
+	if !res.Up && res.Reason == "" {
+		res.Reason = FailureReason(res.Err)
+	}
+	return res
+}
+
+// resultRing keeps the last results of a check, overwriting the oldest one when full.
+type resultRing struct {
+	results []Result
+	next    int
+	full    bool
+}
+
+func newResultRing(size int) *resultRing {
+	return &resultRing{results: make([]Result, size)}
+}
+
+func (r *resultRing) add(res Result) {
+	r.results[r.next] = res
+	r.next = (r.next + 1) % len(r.results)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the results, newest first.
+func (r *resultRing) list() []Result {
+	n := r.next
+	if r.full {
+		n = len(r.results)
+	}
+	results := make([]Result, 0, n)
+	for i := 1; i <= n; i++ {
+		results = append(results, r.results[(r.next-i+len(r.results))%len(r.results)])
+	}
+	return results
+}
+
+func (m *metrics) observe(res Result) {
+	m.checkUp.WithLabelValues(res.Check).Set(boolToFloat(res.Up))
+	m.checkDuration.WithLabelValues(res.Check).Set(res.Duration.Seconds())
+	m.checkLastRun.WithLabelValues(res.Check).Set(float64(res.Timestamp.UnixNano()) / 1e9)
+	if !res.Up {
+		m.checkErrors.WithLabelValues(res.Check).Inc()
+	}
+}
+
+// observeTimings returns a callback recording the request phases timed for check.
+func (m *metrics) observeTimings(check string) func(pksNet.Timings) {
+	return func(t pksNet.Timings) {
+		phases := []struct {
+			name     string
+			duration time.Duration
+		}{
+			{"dns", t.DNS},
+			{"connect", t.Connect},
+			{"tls", t.TLS},
+			{"ttfb", t.TTFB},
+			{"total", t.Total},
+		}
+		for _, p := range phases {
+			// skip the phases that didn't happen, like the handshake of a reused connection
+			if p.duration > 0 {
+				m.httpPhase.WithLabelValues(check, p.name).Observe(p.duration.Seconds())
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeCheck struct {
+	name string
+	up   bool
+	err  error
+}
+
+func (c *fakeCheck) Name() string { return c.name }
+
+func (c *fakeCheck) Run(ctx context.Context) Result {
+	return Result{Up: c.up, Err: c.err}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := newRegistry("test", newMetrics(DefaultMetricNamespace, nil), 0, 0)
+	if err := r.Register(&fakeCheck{name: "a"}, &fakeCheck{name: "b"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := r.Register(&fakeCheck{name: "a"}); err == nil {
+		t.Errorf("Register() duplicate check, want error")
+	}
+	if got := len(r.Checks()); got != 2 {
+		t.Errorf("Checks() got %d checks, want 2", got)
+	}
+}
+
+func TestRegistry_Run(t *testing.T) {
+	m := newMetrics(DefaultMetricNamespace, prometheus.Labels{"foundation": "test"})
+	r := newRegistry("test", m, 0, 0)
+	_ = r.Register(
+		&fakeCheck{name: "registry_up", up: true},
+		&fakeCheck{name: "registry_down", err: errors.New("boom")},
+	)
+
+	results := r.Run(context.Background())
+	if len(results) != 2 {
+		t.Fatalf("Run() got %d results, want 2", len(results))
+	}
+	if results[0].Foundation != "test" || results[0].Check != "registry_up" || !results[0].Up {
+		t.Errorf("Run() got %+v, want registry_up to be up", results[0])
+	}
+	if results[1].Check != "registry_down" || results[1].Up || results[1].Err == nil {
+		t.Errorf("Run() got %+v, want registry_down to be down with error", results[1])
+	}
+
+	tests := []struct {
+		check string
+		want  float64
+	}{
+		{"registry_up", 1},
+		{"registry_down", 0},
+	}
+	for _, tt := range tests {
+		got, ok := gaugeValue(t, m, "wf_opp_check_up", map[string]string{"foundation": "test", "check": tt.check})
+		if !ok || got != tt.want {
+			t.Errorf("wf_opp_check_up{check=%q} = %v (found %t), want %v", tt.check, got, ok, tt.want)
+		}
+	}
+}
+
+// blockingCheck runs until its context is done.
+type blockingCheck struct{}
+
+func (c *blockingCheck) Name() string { return "blocking" }
+
+func (c *blockingCheck) Run(ctx context.Context) Result {
+	<-ctx.Done()
+	return Result{Err: ctx.Err()}
+}
+
+func TestRegistry_Run_Timeout(t *testing.T) {
+	r := newRegistry("test", newMetrics(DefaultMetricNamespace, nil), 10*time.Millisecond, 0)
+	_ = r.Register(&blockingCheck{})
+
+	results := r.Run(context.Background())
+	if results[0].Reason != ReasonTimeout {
+		t.Errorf("Run() got reason %q, want %q", results[0].Reason, ReasonTimeout)
+	}
+}
+
+func TestRegistry_History(t *testing.T) {
+	r := newRegistry("test", newMetrics(DefaultMetricNamespace, nil), 0, 3)
+	check := &fakeCheck{name: "flapping"}
+	_ = r.Register(check)
+
+	for i := 0; i < 5; i++ {
+		check.up = i%2 == 0
+		r.Run(context.Background())
+	}
+
+	history := r.History("flapping")
+	if len(history) != 3 {
+		t.Fatalf("History() got %d results, want 3", len(history))
+	}
+	// runs 4, 3 and 2, newest first
+	for i, want := range []bool{true, false, true} {
+		if history[i].Up != want {
+			t.Errorf("History()[%d].Up = %t, want %t", i, history[i].Up, want)
+		}
+	}
+	if got := r.History("unknown"); got != nil {
+		t.Errorf("History() of an unknown check = %v, want nil", got)
+	}
+}
@@ -0,0 +1,108 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lastActionStates are the states the Pks Api reports for a cluster's last action.
+var lastActionStates = []string{"succeeded", "in progress", "failed"}
+
+// Cluster is a cluster as returned by the Pks Api on GET /v1/clusters.
+type Cluster struct {
+	Name                  string            `json:"name"`
+	UUID                  string            `json:"uuid"`
+	PlanName              string            `json:"plan_name"`
+	KubernetesVersion     string            `json:"k8s_version"`
+	LastAction            string            `json:"last_action"`
+	LastActionState       string            `json:"last_action_state"`
+	LastActionDescription string            `json:"last_action_description"`
+	KubernetesMasterIps   []string          `json:"kubernetes_master_ips"`
+	Parameters            ClusterParameters `json:"parameters"`
+}
+
+// ClusterParameters are the parameters the cluster was created with.
+type ClusterParameters struct {
+	KubernetesMasterHost      string `json:"kubernetes_master_host"`
+	KubernetesMasterPort      int    `json:"kubernetes_master_port"`
+	KubernetesWorkerInstances int    `json:"kubernetes_worker_instances"`
+}
+
+// DecodeClusters decodes the body of a /v1/clusters response.
+func DecodeClusters(r io.Reader) ([]Cluster, error) {
+	var clusters []Cluster
+	if err := json.NewDecoder(r).Decode(&clusters); err != nil {
+		return nil, errors.Wrap(err, "pks-monitor: unable to decode clusters")
+	}
+	return clusters, nil
+}
+
+// clusterSeries remembers the label values of the cluster series last exported,
+// so the series of deleted clusters can be removed without resetting the others.
+type clusterSeries struct {
+	mu     sync.Mutex
+	labels map[*prometheus.GaugeVec]map[string][]string
+}
+
+// recordClusters replaces the exported cluster inventory with clusters, so that
+// deleted clusters stop being reported. The series of the listed clusters are
+// updated in place and only the stale ones are deleted, so a concurrent scrape
+// never sees the inventory empty.
+func (m *metrics) recordClusters(clusters []Cluster) {
+	m.clusterSeries.mu.Lock()
+	defer m.clusterSeries.mu.Unlock()
+
+	series := map[*prometheus.GaugeVec]map[string][]string{}
+	set := func(vec *prometheus.GaugeVec, value float64, labels ...string) {
+		vec.WithLabelValues(labels...).Set(value)
+		if series[vec] == nil {
+			series[vec] = map[string][]string{}
+		}
+		series[vec][strings.Join(labels, "\xff")] = labels
+	}
+
+	for _, c := range clusters {
+		set(m.clusterInfo, 1, c.Name, c.UUID, c.PlanName, c.KubernetesVersion)
+
+		states := lastActionStates
+		if !contains(states, c.LastActionState) {
+			states = append(states[:len(states):len(states)], c.LastActionState)
+		}
+		for _, s := range states {
+			set(m.clusterLastActionState, boolToFloat(s == c.LastActionState), c.Name, c.LastAction, s)
+		}
+
+		set(m.clusterWorkerInstances, float64(c.Parameters.KubernetesWorkerInstances), c.Name)
+		set(m.clusterMasterIps, float64(len(c.KubernetesMasterIps)), c.Name)
+	}
+
+	for vec, last := range m.clusterSeries.labels {
+		for key, labels := range last {
+			if _, ok := series[vec][key]; !ok {
+				vec.DeleteLabelValues(labels...)
+			}
+		}
+	}
+	m.clusterSeries.labels = series
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/pupimvictor/pks-monitor"
+)
+
+// check runs the checks of every foundation once and prints their results. It
+// exits 1 if a check failed, so it can be used in pipelines and cron jobs.
+func check(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to the yaml config file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Printf("check: %+v\n", err)
+		return 2
+	}
+
+	monitors, err := newMonitors(config)
+	if err != nil {
+		fmt.Printf("check: could not authenticate to api: %+v\n", err)
+		return 1
+	}
+	defer monitor.RevokeTokens(monitors)
+
+	failed := false
+	for _, m := range monitors {
+		for _, res := range m.Run(context.Background()) {
+			printResult(res)
+			failed = failed || !res.Up
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func printResult(res monitor.Result) {
+	if res.Up {
+		fmt.Printf("%s %s: up (%s)\n", res.Foundation, res.Check, res.Duration)
+		return
+	}
+	fmt.Printf("%s %s: down (%s): %s: %v\n", res.Foundation, res.Check, res.Duration, res.Reason, res.Err)
+}
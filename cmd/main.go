@@ -1,103 +1,99 @@
+// Command pks-monitor monitors the PKS API of one or more foundations.
+//
+// Usage:
+//
+//	pks-monitor [run] [--config <file>]      check the foundations and serve the metrics
+//	pks-monitor check [--config <file>]      check the foundations once, exit 1 on failure
+//	pks-monitor validate-config [--config <file>]
+//	                                         validate the configuration without network access
+//	pks-monitor version                      print build information
 package main
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/pupimvictor/pks-monitor"
-	"io"
-	"log"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
-)
+	"strings"
 
-func main() {
-	cliId := os.Getenv("UAA_CLI_ID")
-	cliSecret := os.Getenv("UAA_CLI_SECRET")
-	api := os.Getenv("PKS_API")
+	"github.com/pupimvictor/pks-monitor"
+)
 
-	if cliId == "" || cliSecret == "" || api == "" {
-		fmt.Println("main: missing api address or uaa client credentials")
-		os.Exit(1)
-	}
+var commands = map[string]func(args []string) int{
+	"run":             run,
+	"check":           check,
+	"validate-config": validateConfig,
+	"version":         printVersion,
+}
 
-	//set default api check interval to 30 seconds
-	intervalDuration := time.Second * 30
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
+}
 
-	//check for custom interval
-	interval := os.Getenv("API_CHECK_INTERVAL_SECS")
-	if interval != "" {
-		intervalTime, err := time.ParseDuration(fmt.Sprintf("%s%s", interval, "s"))
-		if err != nil {
-			intervalDuration = intervalTime
+// dispatch runs the subcommand named by the first argument. Without one, or with
+// only flags, the monitor runs as it did before subcommands existed.
+func dispatch(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+			usage()
+			return 0
 		}
+		return run(args)
 	}
 
-	pksMonitor, err := monitor.NewPksMonitor(api, cliId, cliSecret)
-	if err != nil {
-		fmt.Printf("main: could not authenticate to api: %+v\n", err)
-		log.Fatal(err)
-	}
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-
-	// setup http server
-	router := mux.NewRouter()
-	router.Handle("/metrics", promhttp.Handler())
-	router.HandleFunc("/healthz", healthz)
-	router.HandleFunc("/prestop", prestop)
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: router,
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "pks-monitor: unknown command %q\n", args[0])
+		usage()
+		return 2
 	}
+	return command(args[1:])
+}
 
-	// create OS signal chan for interruptions
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
-
-	go func() {
-	monitorLoop:
-		for {
-			select {
-			// executes api request every `intervalDuration` seconds.
-			case <-time.Tick(intervalDuration):
-				err := pksMonitor.CheckAPI()
-				if err != nil {
-					fmt.Printf("main: could not check api: %+v\n", err)
-				}
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: pks-monitor <command> [--config <file>]
 
-			// stop process because server stopped working
-			case <-ctx.Done():
-				fmt.Println("main: stopping running. server stopped working")
-				break monitorLoop
+Commands:
+  run              check the foundations every check_interval and serve the metrics (default)
+  check            check the foundations once, exit 1 if a check fails
+  validate-config  validate the configuration without network access
+  version          print build information
+`)
+}
 
-			// stop process because OS signal received
-			case sig := <-done:
-				fmt.Printf("main: stopping running. received OS sig to stop: %s\n", sig.String())
-				break monitorLoop
-			}
+// loadConfig reads the config file at path, when given, and overrides it with the
+// environment variables. Without a config file, the monitor is configured from
+// the environment variables only.
+func loadConfig(path string) (*monitor.MonitorConfig, error) {
+	config := monitor.DefaultMonitorConfig()
+	if path != "" {
+		var err error
+		config, err = monitor.LoadConfig(path)
+		if err != nil {
+			return nil, err
 		}
-	}()
-
-	// start http server
-	err = srv.ListenAndServe()
-	if err != nil {
-		fmt.Printf("main: server stopped: %+v", err)
-		cancelFunc()
 	}
-}
 
-func healthz(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "application/json")
-	_, _ = io.WriteString(w, `{"status":"ok"}`)
+	if err := config.ApplyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+	if len(config.Targets) == 0 {
+		return nil, errors.New("missing api address or uaa client credentials")
+	}
+	if err := config.Validate(os.Getenv); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
-func prestop(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("prestop...")
-	w.Header().Add("Content-Type", "application/json")
-	_, _ = io.WriteString(w, `{"status":"shutting down"}`)
+// newMonitors logs in to every foundation of config.
+func newMonitors(config *monitor.MonitorConfig) ([]*monitor.PksMonitor, error) {
+	var monitors []*monitor.PksMonitor
+	for _, target := range config.Targets {
+		m, err := monitor.NewPksMonitor(target, config.Options())
+		if err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, m)
+	}
+	return monitors, nil
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatch(t *testing.T) {
+	rejected := func(int) (int, string) {
+		return 401, `{"error":"unauthorized","error_description":"Bad credentials"}`
+	}
+
+	tests := []struct {
+		name string
+		// args are the command line arguments, "$CONFIG" is replaced with the config file
+		args      []string
+		grant     func(n int) (int, string)
+		apiStatus int
+		want      int
+	}{
+		{name: "version", args: []string{"version"}, want: 0},
+		{name: "help", args: []string{"--help"}, want: 0},
+		{name: "unknown_command", args: []string{"bogus"}, want: 2},
+		{name: "validate_config", args: []string{"validate-config", "--config", "$CONFIG"}, want: 0},
+		{name: "validate_config_missing_file", args: []string{"validate-config", "--config", "missing.yml"}, want: 1},
+		{name: "validate_config_unknown_flag", args: []string{"validate-config", "--bogus"}, want: 2},
+		{name: "check_up", args: []string{"check", "--config", "$CONFIG"}, want: 0},
+		{name: "check_down", args: []string{"check", "--config", "$CONFIG"}, apiStatus: 503, want: 1},
+		{name: "check_client_rejected", args: []string{"check", "--config", "$CONFIG"}, grant: rejected, want: 1},
+		{name: "check_missing_file", args: []string{"check", "--config", "missing.yml"}, want: 2},
+		{name: "run_missing_file", args: []string{"run", "--config", "missing.yml"}, want: 2},
+		{name: "run_client_rejected", args: []string{"run", "--config", "$CONFIG"}, grant: rejected, want: 1},
+		{name: "default_run_unknown_flag", args: []string{"--bogus"}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant := tt.grant
+			if grant == nil {
+				grant = granted
+			}
+			svr := newFoundation(t, grant, tt.apiStatus)
+			defer svr.Close()
+			config := writeConfig(t, svr.URL)
+			defer os.Remove(config)
+
+			var args []string
+			for _, arg := range tt.args {
+				args = append(args, strings.Replace(arg, "$CONFIG", config, 1))
+			}
+			if got := dispatch(args); got != tt.want {
+				t.Errorf("dispatch(%v) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// foundation is a fake PKS API and UAA. The n-th token grant is answered by
+// grant, with a token granting pks.clusters.admin when the body is empty, and
+// the clusters are listed with apiStatus, 200 when zero.
+type foundation struct {
+	grant     func(n int) (int, string)
+	apiStatus int
+
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	grants int
+}
+
+func newFoundation(t *testing.T, grant func(n int) (int, string), apiStatus int) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiStatus == 0 {
+		apiStatus = http.StatusOK
+	}
+	f := &foundation{grant: grant, apiStatus: apiStatus, key: key}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.serveHTTP(t, w, r)
+	}))
+}
+
+func (f *foundation) serveHTTP(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth/token":
+		f.mu.Lock()
+		f.grants++
+		code, body := f.grant(f.grants)
+		f.mu.Unlock()
+		if body == "" {
+			body = fmt.Sprintf(`{"access_token":%q,"expires_in":3600}`, f.token(t))
+		}
+		w.WriteHeader(code)
+		fmt.Fprintln(w, body)
+	case "/token_keys":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "key-1",
+			"kty": "RSA",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}}})
+	case "/healthz":
+		fmt.Fprintln(w, "ok")
+	case "/info":
+		fmt.Fprintln(w, `{"app":{"version":"4.30.0"}}`)
+	case "/v1/clusters":
+		w.WriteHeader(f.apiStatus)
+		fmt.Fprintln(w, "[]")
+	}
+}
+
+// token returns a JWT signed as UAA would.
+func (f *foundation) token(t *testing.T) string {
+	encode := func(v interface{}) string {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": "key-1"}) + "." + encode(map[string]interface{}{
+		"jti":       "token-id",
+		"iat":       time.Now().Unix(),
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"scope":     []string{"pks.clusters.admin"},
+		"client_id": "id",
+	})
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writeConfig writes a config file monitoring the foundation at url and returns its path.
+func writeConfig(t *testing.T, url string) string {
+	file, err := ioutil.TempFile("", "config*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, `
+listen_address: 127.0.0.1:0
+targets:
+- name: test
+  api: %s
+  uaa: %s
+  uaa_cli_id: id
+  uaa_cli_secret: secret
+  tls: {insecure_skip_verify: true}
+`, url, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+// granted answers every token grant.
+func granted(int) (int, string) { return 200, "" }
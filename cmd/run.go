@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/pupimvictor/pks-monitor"
+)
+
+// run starts the monitor: it checks the foundations every check_interval and
+// serves the metrics until it's stopped.
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to the yaml config file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Printf("main: %+v\n", err)
+		return 2
+	}
+
+	monitors, err := newMonitors(config)
+	if err != nil {
+		fmt.Printf("main: could not authenticate to api: %+v\n", err)
+		return 1
+	}
+	// serve the metrics of the foundations along with the ones of the process, like promhttp.Handler
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	for _, m := range monitors {
+		registry.MustRegister(m)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	// log in to the foundations whose UAA couldn't be reached yet, then refresh
+	// the access tokens in the background before they expire. The monitor shuts
+	// down when UAA rejects the client, as it would have at startup.
+	loginFailed := make(chan error, len(monitors))
+	for _, m := range monitors {
+		m.Tokens().RefreshFraction = config.TokenRefreshFraction
+		go func(m *monitor.PksMonitor) {
+			if err := m.Login(ctx); err != nil {
+				if ctx.Err() == nil {
+					loginFailed <- errors.Wrapf(err, "%s: couldn't login", m.Foundation())
+				}
+				return
+			}
+			m.Tokens().Start(ctx)
+		}(m)
+		go m.WatchCredentials(ctx, config.ReloadInterval)
+	}
+
+	// in scrape mode the checks run when the metrics are scraped, not on a schedule
+	var heartbeat *monitor.Heartbeat
+	interval := time.Duration(0)
+	if config.Mode == monitor.ModeInterval {
+		interval = config.CheckInterval
+		heartbeat = monitor.NewHeartbeat(config.CheckInterval, config.LivenessIntervals)
+	}
+	lifecycle := monitor.NewLifecycle(monitors, interval, heartbeat)
+	lifecycle.DrainTimeout = config.CheckTimeout
+
+	// setup http server
+	router := mux.NewRouter()
+	router.Handle("/metrics", promhttp.InstrumentMetricHandler(registry, promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
+	router.HandleFunc("/healthz", healthz)
+	router.Handle("/livez", monitor.LiveHandler(heartbeat))
+	router.Handle("/readyz", monitor.ReadyHandler(monitors))
+	router.Handle("/api/v1/status", monitor.StatusHandler(monitors))
+	router.Handle("/api/v1/checks/{name}/history", monitor.HistoryHandler(monitors))
+	router.Handle("/probe", monitor.ProbeHandler(monitors, config.CheckTimeout))
+	router.Handle("/", monitor.DashboardHandler())
+	router.Handle("/dashboard", monitor.DashboardHandler())
+	router.Handle("/prestop", prestop(lifecycle, config.ShutdownTimeout))
+	srv := &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: router,
+	}
+	lifecycle.Server = srv
+
+	// push the metrics of the foundations to wavefront, a last time once the checks drained
+	if config.Wavefront.Proxy != "" {
+		foundations := prometheus.NewRegistry()
+		for _, m := range monitors {
+			foundations.MustRegister(m)
+		}
+		exporter := monitor.NewWavefrontExporter(config.Wavefront, foundations)
+		exportCtx, stopExport := context.WithCancel(ctx)
+		go exporter.Run(exportCtx)
+		lifecycle.OnDrain(func(ctx context.Context) error {
+			stopExport()
+			err := exporter.Flush(ctx)
+			_ = exporter.Close()
+			return err
+		})
+	}
+
+	// executes the registered checks every `check_interval`, in interval mode
+	go lifecycle.Run(ctx)
+
+	// shut down cleanly on SIGTERM, which Kubernetes sends after the preStop hook
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	shutdown := make(chan struct{})
+	exitCode := 0
+	go func() {
+		defer close(shutdown)
+		select {
+		case sig := <-signals:
+			fmt.Printf("main: received %s, shutting down\n", sig)
+		case err := <-loginFailed:
+			fmt.Printf("main: %+v\n", err)
+			exitCode = 1
+		case <-ctx.Done():
+			return
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		if err := lifecycle.Shutdown(shutdownCtx); err != nil {
+			fmt.Printf("main: server shutdown: %+v\n", err)
+		}
+		cancelFunc()
+	}()
+
+	// start http server
+	err = srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Printf("main: server stopped: %+v\n", err)
+		cancelFunc()
+		return 1
+	}
+	// ListenAndServe returns as soon as Shutdown starts, wait for the requests to finish
+	<-shutdown
+	return exitCode
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	_, _ = io.WriteString(w, `{"status":"ok"}`)
+}
+
+// prestop drains the monitor before Kubernetes sends SIGTERM: the checks stop
+// and the tokens are revoked, while the server keeps serving until SIGTERM.
+func prestop(lifecycle *monitor.Lifecycle, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("prestop...")
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		lifecycle.Drain(ctx)
+		w.Header().Add("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"status":"shutting down"}`)
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRun_LoginRejected(t *testing.T) {
+	// UAA is unavailable at startup, then rejects the client
+	svr := newFoundation(t, func(n int) (int, string) {
+		if n == 1 {
+			return 503, "Service Unavailable"
+		}
+		return 401, `{"error":"unauthorized","error_description":"Bad credentials"}`
+	}, 200)
+	defer svr.Close()
+	config := writeConfig(t, svr.URL)
+	defer os.Remove(config)
+
+	exit := make(chan int)
+	go func() { exit <- run([]string{"--config", config}) }()
+	select {
+	case got := <-exit:
+		if got != 1 {
+			t.Errorf("run() = %d, want 1", got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run() didn't exit once UAA rejected the client")
+	}
+}
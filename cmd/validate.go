@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// validateConfig loads and validates the configuration, with the environment
+// variable overrides, without calling the foundations.
+func validateConfig(args []string) int {
+	flags := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to the yaml config file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Printf("validate-config: %+v\n", err)
+		return 1
+	}
+	fmt.Printf("validate-config: configuration is valid, %d target(s)\n", len(config.Targets))
+	return 0
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+func printVersion(args []string) int {
+	v := version
+	if v == "dev" {
+		// go install records the module version
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+	}
+	fmt.Printf("pks-monitor %s (commit %s, built %s, %s %s/%s)\n", v, commit, date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
@@ -1,11 +1,17 @@
 package monitor
 
 import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
 	"github.com/pkg/errors"
 	pksNet "github.com/pupimvictor/pks-monitor/net"
 	"github.com/pupimvictor/pks-monitor/uaa"
 	"net/http"
 	"net/url"
+	"sync"
 )
 
 // Config represents the configuration for the PKS CLI. This includes things
@@ -15,57 +21,229 @@ type Config struct {
 	CACert              string `yaml:"ca_cert"`
 	Username            string `yaml:"username"`
 	SkipSSLVerification bool   `yaml:"skip_ssl_verification"`
-	AccessToken         string `yaml:"access_token"`
 	RefreshToken        string `yaml:"refresh_token"`
 
+	// UAA is the URL of the UAA server, with its port.
+	UAA          string
 	UaaCliId     string
 	UaaCliSecret string
+
+	// MinTLSVersion and SystemRoots tune how the certificates of the PKS API and
+	// UAA are verified, see pksNet.TLSOptions. ServerName and PinnedSPKI apply to
+	// the PKS API only, UAAServerName and UAAPinnedSPKI to UAA, which may be
+	// another host.
+	MinTLSVersion uint16
+	SystemRoots   bool
+	ServerName    string
+	PinnedSPKI    []string
+	UAAServerName string
+	UAAPinnedSPKI []string
+	// OnVerify is called on every handshake with the PKS API and UAA, with the
+	// certificates they presented and why they didn't verify.
+	OnVerify func(addr string, certs []*x509.Certificate, err error)
+
+	// tokens holds the access token, which is shared by concurrent checks
+	tokens pksNet.SyncTokenStore
+
+	// mu guards CACert and UaaCliSecret, which are reloaded when their files change,
+	// and the transports, which are rebuilt when CACert changes
+	mu           sync.RWMutex
+	apiTransport *http.Transport
+	uaaTransport *http.Transport
 }
 
-var (
-	// APIPort is the port used to communicate with the PKS API.
-	APIPort = "9021"
-	// UAAPort is the port used to communicate with the PKS UAA.
-	UAAPort = "8443"
+const (
+	// DefaultAPIPort is the port of the PKS API, used when its URL has none.
+	DefaultAPIPort = "9021"
+	// DefaultUAAPort is the port of the PKS UAA, used when its URL has none.
+	DefaultUAAPort = "8443"
 )
 
 // GetAccessToken returns the access token.
 func (c *Config) GetAccessToken() string {
-	return c.AccessToken
+	return c.tokens.GetAccessToken()
 }
 
 // SetAccessToken sets the access token.
 func (c *Config) SetAccessToken(at string) {
-	c.AccessToken = at
+	c.tokens.SetAccessToken(at)
+}
+
+// GetCACert returns the PEM encoded CA certificates of the PKS API.
+func (c *Config) GetCACert() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.CACert
+}
+
+// GetUaaCliSecret returns the secret of the UAA client.
+func (c *Config) GetUaaCliSecret() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.UaaCliSecret
+}
+
+// SetCredentials replaces the secret of the UAA client and the CA certificates.
+// When the CA certificates change, the transports are rebuilt with them first:
+// when they're invalid, SetCredentials fails and the current credentials are kept.
+func (c *Config) SetCredentials(secret, caCert string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if caCert != c.CACert {
+		apiTransport, err := pksNet.Transport(c.apiTLSOptions(caCert))
+		if err != nil {
+			return errors.Wrap(err, "pks-monitor: invalid ca cert")
+		}
+		uaaTransport, err := pksNet.Transport(c.uaaTLSOptions(caCert))
+		if err != nil {
+			return errors.Wrap(err, "pks-monitor: invalid ca cert")
+		}
+		for _, transport := range []*http.Transport{c.apiTransport, c.uaaTransport} {
+			if transport != nil {
+				transport.CloseIdleConnections()
+			}
+		}
+		c.apiTransport, c.uaaTransport = apiTransport, uaaTransport
+		c.CACert = caCert
+	}
+	c.UaaCliSecret = secret
+	return nil
+}
+
+// TLSOptions returns how the certificates of the PKS API are verified.
+func (c *Config) TLSOptions() pksNet.TLSOptions {
+	return c.apiTLSOptions(c.GetCACert())
+}
+
+// UAATLSOptions returns how the certificates of UAA are verified.
+func (c *Config) UAATLSOptions() pksNet.TLSOptions {
+	return c.uaaTLSOptions(c.GetCACert())
+}
+
+func (c *Config) apiTLSOptions(caCert string) pksNet.TLSOptions {
+	opts := c.tlsOptions(caCert)
+	opts.ServerName = c.ServerName
+	opts.PinnedSPKI = c.PinnedSPKI
+	return opts
+}
+
+func (c *Config) uaaTLSOptions(caCert string) pksNet.TLSOptions {
+	opts := c.tlsOptions(caCert)
+	opts.ServerName = c.UAAServerName
+	opts.PinnedSPKI = c.UAAPinnedSPKI
+	return opts
+}
+
+func (c *Config) tlsOptions(caCert string) pksNet.TLSOptions {
+	return pksNet.TLSOptions{
+		Insecure:    c.SkipSSLVerification,
+		CACerts:     []byte(caCert),
+		SystemRoots: c.SystemRoots,
+		MinVersion:  c.MinTLSVersion,
+		OnVerify:    c.verified,
+	}
+}
+
+// verified calls OnVerify, which may be set once the transports were built.
+func (c *Config) verified(addr string, certs []*x509.Certificate, err error) {
+	if c.OnVerify != nil {
+		c.OnVerify(addr, certs, err)
+	}
 }
 
-func CreateHttpClient(c *Config) (*http.Client, error) {
-	apiHTTPClient, err := pksNet.HTTPClient(c.SkipSSLVerification, []byte(c.CACert))
+// Transport returns the transport of the clients of the PKS API, so they reuse
+// their connections. It's rebuilt when the CA certificates change.
+func (c *Config) Transport() (*http.Transport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.apiTransport == nil {
+		transport, err := pksNet.Transport(c.apiTLSOptions(c.CACert))
+		if err != nil {
+			return nil, err
+		}
+		c.apiTransport = transport
+	}
+	return c.apiTransport, nil
+}
+
+// UAATransport returns the transport of the clients of UAA, like Transport.
+func (c *Config) UAATransport() (*http.Transport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.uaaTransport == nil {
+		transport, err := pksNet.Transport(c.uaaTLSOptions(c.CACert))
+		if err != nil {
+			return nil, err
+		}
+		c.uaaTransport = transport
+	}
+	return c.uaaTransport, nil
+}
+
+// probeTransport returns a transport of the PKS API of its own, for probes. Its
+// handshakes aren't reported to OnVerify and, as every probe connects anew, it
+// doesn't keep its connections alive.
+func (c *Config) probeTransport() (*http.Transport, error) {
+	return probeTransport(c.TLSOptions())
+}
+
+// probeUAATransport returns a transport of UAA for probes, like probeTransport.
+func (c *Config) probeUAATransport() (*http.Transport, error) {
+	return probeTransport(c.UAATLSOptions())
+}
+
+func probeTransport(opts pksNet.TLSOptions) (*http.Transport, error) {
+	opts.OnVerify = nil
+	transport, err := pksNet.Transport(opts)
+	if err != nil {
+		return nil, err
+	}
+	transport.DisableKeepAlives = true
+	return transport, nil
+}
+
+// endpointURL parses the URL of the PKS API or UAA, adding defaultPort when it has no port.
+func endpointURL(raw, defaultPort string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("%q isn't an http(s) url", raw)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+// CreateHttpClient creates a client authorized with the access token of c. Expired
+// tokens are replaced with a token from src.
+func CreateHttpClient(c *Config, src pksNet.TokenSource) (*http.Client, error) {
+	transport, err := c.Transport()
 	if err != nil {
 		return nil, errors.Wrap(err, "monitor: could not create HTTPClient")
 	}
-	apiHTTPClient.Transport = pksNet.NewAuthTransport(
-		apiHTTPClient.Transport,
-		c,
-		c.UaaCliId,
-		c.UaaCliSecret,
-	)
-	return apiHTTPClient, nil
+	return pksNet.HTTPClient(pksNet.NewAuthTransport(transport, c, src)), nil
 }
 
 func CreateUaaClient(c *Config) (*uaa.Client, error) {
-	uaaHTTPClient, err := pksNet.HTTPClient(c.SkipSSLVerification, []byte(c.CACert))
+	transport, err := c.UAATransport()
 	if err != nil {
 		return nil, errors.Wrap(err, "monitor: could not create HTTPClient")
 	}
-	u, err := url.Parse(c.API)
+	return newUaaClient(c, transport)
+}
+
+func newUaaClient(c *Config, transport http.RoundTripper) (*uaa.Client, error) {
+	u, err := url.Parse(c.UAA)
 	if err != nil {
 		return nil, err
 	}
-	u.Host = u.Host + ":" + UAAPort
 	uaaClient := &uaa.Client{
 		AuthURL: *u,
-		Client:  uaaHTTPClient,
+		Client:  pksNet.HTTPClient(transport),
 	}
 	return uaaClient, nil
 }
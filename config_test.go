@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pksNet "github.com/pupimvictor/pks-monitor/net"
+)
+
+func TestEndpointURL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{raw: "https://api.pks.example.com", want: "https://api.pks.example.com:9021"},
+		{raw: "https://api.pks.example.com/", want: "https://api.pks.example.com:9021"},
+		{raw: "https://api.pks.example.com:443", want: "https://api.pks.example.com:443"},
+		{raw: "https://[::1]", want: "https://[::1]:9021"},
+		{raw: "api.pks.example.com", wantErr: true},
+		{raw: "ftp://api.pks.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := endpointURL(tt.raw, DefaultAPIPort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("endpointURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("endpointURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverUaa(t *testing.T) {
+	tests := []struct {
+		name string
+		info string
+		want func(candidate string) string
+	}{
+		{
+			name: "advertised",
+			info: `{"links": {"uaa": "https://uaa.pks.example.com"}}`,
+			want: func(string) string { return "https://uaa.pks.example.com" },
+		},
+		{
+			name: "not_advertised",
+			want: func(candidate string) string { return candidate },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/info" || tt.info == "" {
+					w.WriteHeader(404)
+					return
+				}
+				fmt.Fprintln(w, tt.info)
+			}))
+			defer svr.Close()
+
+			if got := discoverUaa(&Config{SkipSSLVerification: true}, svr.URL); got != tt.want(svr.URL) {
+				t.Errorf("discoverUaa() = %v, want %v", got, tt.want(svr.URL))
+			}
+		})
+	}
+}
+
+func TestConfig_Transports(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer svr.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw}))
+	otherPin := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	tests := []struct {
+		name       string
+		config     *Config
+		wantAPIErr bool
+		wantUAAErr bool
+	}{
+		{"verified", &Config{CACert: caCert}, false, false},
+		// the server name and pins of the api don't apply to uaa, which may be another host
+		{"api_server_name", &Config{CACert: caCert, ServerName: "pks.example.org"}, true, false},
+		{"api_pins", &Config{CACert: caCert, PinnedSPKI: []string{otherPin}}, true, false},
+		{"uaa_server_name", &Config{CACert: caCert, UAAServerName: "uaa.example.org"}, false, true},
+		{"uaa_pins", &Config{CACert: caCert, UAAPinnedSPKI: []string{otherPin}}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, endpoint := range []struct {
+				name      string
+				transport func() (*http.Transport, error)
+				wantErr   bool
+			}{
+				{"api", tt.config.Transport, tt.wantAPIErr},
+				{"uaa", tt.config.UAATransport, tt.wantUAAErr},
+			} {
+				transport, err := endpoint.transport()
+				if err != nil {
+					t.Fatalf("%s transport error = %v", endpoint.name, err)
+				}
+				res, err := pksNet.HTTPClient(transport).Get(svr.URL)
+				if (err != nil) != endpoint.wantErr {
+					t.Errorf("Get() of %s error = %v, wantErr %v", endpoint.name, err, endpoint.wantErr)
+				}
+				if err == nil {
+					res.Body.Close()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package monitor
+
+import (
+	"io"
+	"net/http"
+)
+
+// DashboardHandler serves a status page for operators without Grafana access.
+// The page is self-contained, it loads nothing but the JSON status API, which it
+// polls every 15 seconds.
+func DashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+		_, _ = io.WriteString(w, dashboardHTML)
+	})
+}
+
+// dashboardHTML is the status page. Its requests are relative to the page, so it
+// works behind a proxy serving the monitor under a path prefix.
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PKS Monitor</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; background: #f6f7f9; }
+  h1 { font-size: 1.4em; margin: 0 0 .2em; }
+  h2 { font-size: 1.1em; margin: 1.5em 0 .5em; }
+  .updated { color: #777; font-size: .85em; }
+  .error { color: #b00020; }
+  .foundations { display: flex; flex-wrap: wrap; gap: 1em; }
+  .card { background: #fff; border-radius: 6px; box-shadow: 0 1px 3px rgba(0,0,0,.15); padding: 1em; min-width: 18em; }
+  .card h3 { margin: 0 0 .6em; font-size: 1em; }
+  .check { display: flex; align-items: center; gap: .6em; margin: .4em 0; }
+  .check .name { width: 5em; }
+  .badge { border-radius: 3px; color: #fff; font-size: .8em; padding: .15em .5em; width: 3.5em; text-align: center; }
+  .up { background: #2e7d32; }
+  .down { background: #c62828; }
+  .unknown { background: #9e9e9e; }
+  .latency { color: #555; font-size: .85em; }
+  table { border-collapse: collapse; background: #fff; box-shadow: 0 1px 3px rgba(0,0,0,.15); font-size: .9em; }
+  th, td { padding: .4em .8em; text-align: left; border-bottom: 1px solid #eee; }
+  th { background: #fafafa; }
+  td.failed { color: #c62828; }
+</style>
+</head>
+<body>
+<h1>PKS Monitor</h1>
+<div class="updated" id="updated">loading...</div>
+
+<h2>Foundations</h2>
+<div class="foundations" id="foundations"></div>
+
+<h2>Clusters</h2>
+<table>
+  <thead><tr><th>Foundation</th><th>Cluster</th><th>Plan</th><th>Kubernetes</th><th>Last action</th><th>State</th><th>Workers</th><th>Masters</th></tr></thead>
+  <tbody id="clusters"></tbody>
+</table>
+
+<h2>Recent failures</h2>
+<table>
+  <thead><tr><th>Time</th><th>Foundation</th><th>Check</th><th>Reason</th><th>Status</th><th>Error</th></tr></thead>
+  <tbody id="failures"></tbody>
+</table>
+
+<script>
+"use strict";
+
+var refreshMillis = 15000;
+var maxFailures = 20;
+
+function escapeHTML(s) {
+  return String(s === undefined || s === null ? "" : s).replace(/[&<>"']/g, function (c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;", "'": "&#39;"}[c];
+  });
+}
+
+function getJSON(path) {
+  return fetch(path, {headers: {"Accept": "application/json"}}).then(function (res) {
+    if (!res.ok) {
+      throw new Error(path + ": " + res.status);
+    }
+    return res.json();
+  });
+}
+
+// sparkline draws the durations of results, oldest first, failures in red.
+function sparkline(results) {
+  var width = 160, height = 28;
+  if (results.length === 0) {
+    return "";
+  }
+  var max = Math.max.apply(null, results.map(function (r) { return r.duration_seconds; })) || 1;
+  var step = results.length > 1 ? width / (results.length - 1) : 0;
+  var points = results.map(function (r, i) {
+    return [i * step, height - 2 - (r.duration_seconds / max) * (height - 4)];
+  });
+  var line = points.map(function (p) { return p[0].toFixed(1) + "," + p[1].toFixed(1); }).join(" ");
+  var dots = results.map(function (r, i) {
+    return r.up ? "" : "<circle cx=\"" + points[i][0].toFixed(1) + "\" cy=\"" + points[i][1].toFixed(1) + "\" r=\"2.5\" fill=\"#c62828\"/>";
+  }).join("");
+  return "<svg width=\"" + width + "\" height=\"" + height + "\"><polyline fill=\"none\" stroke=\"#1565c0\" stroke-width=\"1.5\" points=\"" + line + "\"/>" + dots + "</svg>";
+}
+
+function renderFoundations(status, histories) {
+  var foundations = {};
+  var checks = [];
+  status.checks.forEach(function (c) {
+    foundations[c.foundation] = foundations[c.foundation] || {};
+    foundations[c.foundation][c.check] = c;
+    if (checks.indexOf(c.check) < 0) {
+      checks.push(c.check);
+    }
+  });
+
+  var html = Object.keys(foundations).sort().map(function (name) {
+    var rows = checks.map(function (check) {
+      var last = foundations[name][check];
+      var history = (histories[check] || []).filter(function (r) { return r.foundation === name; }).reverse();
+      var state = !last ? "unknown" : (last.up ? "up" : "down");
+      var latency = last ? (last.duration_seconds * 1000).toFixed(0) + " ms" : "";
+      return "<div class=\"check\"><span class=\"name\">" + escapeHTML(check) + "</span>" +
+        "<span class=\"badge " + state + "\" title=\"" + escapeHTML(last && last.error) + "\">" + state + "</span>" +
+        sparkline(history) + "<span class=\"latency\">" + latency + "</span></div>";
+    }).join("");
+    return "<div class=\"card\"><h3>" + escapeHTML(name) + "</h3>" + rows + "</div>";
+  }).join("");
+  document.getElementById("foundations").innerHTML = html || "<p>No check ran yet.</p>";
+}
+
+function renderClusters(status) {
+  var rows = (status.clusters || []).map(function (c) {
+    var failed = c.last_action_state === "failed" ? " class=\"failed\"" : "";
+    return "<tr><td>" + escapeHTML(c.foundation) + "</td><td>" + escapeHTML(c.name) + "</td><td>" +
+      escapeHTML(c.plan_name) + "</td><td>" + escapeHTML(c.k8s_version) + "</td><td>" +
+      escapeHTML(c.last_action) + "</td><td" + failed + ">" + escapeHTML(c.last_action_state) + "</td><td>" +
+      escapeHTML(c.parameters.kubernetes_worker_instances) + "</td><td>" +
+      escapeHTML((c.kubernetes_master_ips || []).join(", ")) + "</td></tr>";
+  });
+  document.getElementById("clusters").innerHTML = rows.join("") || "<tr><td colspan=\"8\">No clusters listed yet.</td></tr>";
+}
+
+function renderFailures(histories) {
+  var failures = [];
+  Object.keys(histories).forEach(function (check) {
+    histories[check].forEach(function (r) {
+      if (!r.up) {
+        failures.push(r);
+      }
+    });
+  });
+  failures.sort(function (a, b) { return a.timestamp < b.timestamp ? 1 : -1; });
+
+  var rows = failures.slice(0, maxFailures).map(function (r) {
+    return "<tr><td>" + escapeHTML(new Date(r.timestamp).toLocaleString()) + "</td><td>" +
+      escapeHTML(r.foundation) + "</td><td>" + escapeHTML(r.check) + "</td><td>" + escapeHTML(r.reason) +
+      "</td><td>" + escapeHTML(r.status_code || "") + "</td><td>" + escapeHTML(r.error) + "</td></tr>";
+  });
+  document.getElementById("failures").innerHTML = rows.join("") || "<tr><td colspan=\"6\">No recent failures.</td></tr>";
+}
+
+function refresh() {
+  var updated = document.getElementById("updated");
+  getJSON("api/v1/status").then(function (status) {
+    var checks = [];
+    status.checks.forEach(function (c) {
+      if (checks.indexOf(c.check) < 0) {
+        checks.push(c.check);
+      }
+    });
+    return Promise.all(checks.map(function (check) {
+      return getJSON("api/v1/checks/" + encodeURIComponent(check) + "/history");
+    })).then(function (responses) {
+      var histories = {};
+      responses.forEach(function (h) { histories[h.check] = h.results; });
+      renderFoundations(status, histories);
+      renderClusters(status);
+      renderFailures(histories);
+      updated.className = "updated";
+      updated.textContent = "updated " + new Date().toLocaleTimeString();
+    });
+  }).catch(function (err) {
+    updated.className = "updated error";
+    updated.textContent = "couldn't refresh: " + err.message;
+  }).then(function () {
+    setTimeout(refresh, refreshMillis);
+  });
+}
+
+refresh();
+</script>
+</body>
+</html>
+`
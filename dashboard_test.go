@@ -0,0 +1,28 @@
+package monitor
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	DashboardHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`getJSON("api/v1/status")`, `"/history"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard doesn't contain %s", want)
+		}
+	}
+	// the page must work without internet access
+	for _, external := range []string{"http://", "https://", "//cdn", " src="} {
+		if strings.Contains(body, external) {
+			t.Errorf("dashboard loads external asset %q", external)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package monitor
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/pkg/errors"
+	pksNet "github.com/pupimvictor/pks-monitor/net"
+)
+
+// Reasons a check can fail for.
+const (
+	ReasonDNS            = "dns"
+	ReasonConnectRefused = "connect_refused"
+	ReasonTimeout        = "timeout"
+	ReasonTLSVerify      = "tls_verify"
+	ReasonHTTP4xx        = "http_4xx"
+	ReasonHTTP5xx        = "http_5xx"
+	ReasonAuthRejected   = "auth_rejected"
+	ReasonTokenExpired   = "token_expired"
+	ReasonDecodeError    = "decode_error"
+	// ReasonUnknown is used for the errors that don't fit any other reason.
+	ReasonUnknown = "unknown"
+)
+
+// Failure is an error explaining why a check failed.
+type Failure struct {
+	Reason     string
+	StatusCode int
+	Err        error
+}
+
+func (f *Failure) Error() string {
+	if f.Err == nil {
+		return f.Reason
+	}
+	return fmt.Sprintf("%s: %v", f.Reason, f.Err)
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// statusFailure classifies an unsuccessful http response.
+func statusFailure(statusCode int) *Failure {
+	reason := ReasonHTTP5xx
+	switch {
+	case statusCode == 401 || statusCode == 403:
+		reason = ReasonAuthRejected
+	case statusCode < 500:
+		reason = ReasonHTTP4xx
+	}
+	return &Failure{
+		Reason:     reason,
+		StatusCode: statusCode,
+		Err:        fmt.Errorf("response status code: %d", statusCode),
+	}
+}
+
+// FailureReason returns why err made a check fail. Errors that aren't a Failure
+// are classified from the network or TLS error they wrap.
+func FailureReason(err error) string {
+	var failure *Failure
+	if errors.As(err, &failure) {
+		return failure.Reason
+	}
+
+	var refreshErr *pksNet.TokenRefreshError
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &refreshErr):
+		return ReasonTokenExpired
+	case errors.As(err, &dnsErr):
+		return ReasonDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ReasonConnectRefused
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonTimeout
+	case errors.As(err, &unknownAuthority),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidCert),
+		errors.Is(err, pksNet.ErrPinMismatch):
+		return ReasonTLSVerify
+	}
+	return ReasonUnknown
+}
+
+// statusCode returns the http status code of the response the check failed on, if any.
+func statusCode(err error) int {
+	var failure *Failure
+	if errors.As(err, &failure) {
+		return failure.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestFailureReason(t *testing.T) {
+	tlsSvr := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsSvr.Close()
+
+	// a listener closed right away leaves a port nobody listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	get := func(client *http.Client, url string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+		res, err := client.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return errors.Wrap(err, "pks-monitor: unable to make API request")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"dns", errors.Wrap(&net.DNSError{Err: "no such host", Name: "api.pks.invalid"}, "lookup"), ReasonDNS},
+		{"connect_refused", get(http.DefaultClient, "http://"+closedAddr), ReasonConnectRefused},
+		{"timeout", errors.Wrap(context.DeadlineExceeded, "request"), ReasonTimeout},
+		{"tls_verify", get(&http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{}}}, tlsSvr.URL), ReasonTLSVerify},
+		{"http_5xx", statusFailure(502), ReasonHTTP5xx},
+		{"http_4xx", statusFailure(404), ReasonHTTP4xx},
+		{"auth_rejected", statusFailure(403), ReasonAuthRejected},
+		{"wrapped_failure", errors.Wrap(&Failure{Reason: ReasonDecodeError}, "pks-monitor"), ReasonDecodeError},
+		{"unknown", errors.New("boom"), ReasonUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FailureReason(tt.err); got != tt.want {
+				t.Errorf("FailureReason(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApiCheck_Run_Reason(t *testing.T) {
+	tests := []struct {
+		name     string
+		respCode int
+		resp     string
+		want     string
+	}{
+		{"http_5xx", 503, `{"error":"unavailable"}`, ReasonHTTP5xx},
+		{"http_4xx", 404, `not found`, ReasonHTTP4xx},
+		{"auth_rejected", 403, `{"error":"access_denied"}`, ReasonAuthRejected},
+		{"decode_error", 200, `not json`, ReasonDecodeError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.respCode)
+				fmt.Fprintln(w, tt.resp)
+			}))
+			defer svr.Close()
+
+			pks := newTestMonitor(t, svr.URL, "", "fakeToken")
+			res := (&apiCheck{pks: pks}).Run(context.Background())
+			if res.Up || res.Reason != tt.want {
+				t.Errorf("Run() got = %+v, want reason %v", res, tt.want)
+			}
+			got, _ := counterValue(t, pks.metrics.pksApiFailures, "wf_opp_pks_api_check_failures_total", map[string]string{"reason": tt.want})
+			if got != 1 {
+				t.Errorf("wf_opp_pks_api_check_failures_total{reason=%q} = %v, want 1", tt.want, got)
+			}
+		})
+	}
+}
@@ -3,11 +3,12 @@ module github.com/pupimvictor/pks-monitor
 go 1.13
 
 require (
-	code.cloudfoundry.org/credhub-cli v0.0.0-20191230184144-6e537b521d9d
 	code.cloudfoundry.org/uaa-cli v0.0.0-20200115111735-3c8841ec3334 // indirect
 	github.com/gorilla/mux v1.7.3
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.8.1
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.3.0
+	github.com/prometheus/client_model v0.1.0
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultLivenessIntervals is how many check intervals the scheduler may go
+// without completing a cycle before the monitor is reported dead.
+const DefaultLivenessIntervals = 3
+
+// Heartbeat records the cycles completed by the check scheduler, so a scheduler
+// that died or is stuck in a check can be detected.
+type Heartbeat struct {
+	interval time.Duration
+	missed   int
+
+	mu     sync.RWMutex
+	start  time.Time
+	last   time.Time
+	cycles int
+}
+
+// NewHeartbeat returns a Heartbeat of a scheduler running every interval, which
+// is alive as long as it completes a cycle within missed intervals.
+func NewHeartbeat(interval time.Duration, missed int) *Heartbeat {
+	return &Heartbeat{
+		interval: interval,
+		missed:   missed,
+		start:    time.Now(),
+	}
+}
+
+// Beat records that the scheduler completed a cycle.
+func (h *Heartbeat) Beat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = time.Now()
+	h.cycles++
+}
+
+// Alive returns an error when the scheduler didn't complete a cycle within the
+// missed intervals before now, counted from its start until its first cycle.
+func (h *Heartbeat) Alive(now time.Time) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	last := h.last
+	if h.cycles == 0 {
+		last = h.start
+	}
+	if deadline := time.Duration(h.missed) * h.interval; now.Sub(last) > deadline {
+		if h.cycles == 0 {
+			return fmt.Errorf("no check cycle completed in the %s since the start", now.Sub(last).Round(time.Second))
+		}
+		return fmt.Errorf("no check cycle completed since %s", last.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SubsystemStatus is the health of a part of the monitor.
+type SubsystemStatus struct {
+	Name       string `json:"name"`
+	Foundation string `json:"foundation,omitempty"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newSubsystemStatus(name, foundation string, err error) SubsystemStatus {
+	status := SubsystemStatus{Name: name, Foundation: foundation, OK: err == nil}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// ReadyHandler serves whether every foundation has a valid access token. It
+// answers 503 until the first token is granted, once a token expired without
+// being refreshed and once the tokens were revoked on shutdown.
+func ReadyHandler(monitors []*PksMonitor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		subsystems := []SubsystemStatus{}
+		for _, m := range monitors {
+			subsystems = append(subsystems, newSubsystemStatus("auth", m.Foundation(), m.Tokens().Ready(now)))
+		}
+		writeHealth(w, subsystems)
+	})
+}
+
+// LiveHandler serves whether the check scheduler is still completing cycles. It
+// answers 503 when it's not, so Kubernetes restarts a wedged monitor. Without
+// heartbeat, as checks run on scrape, the monitor is alive while it serves.
+func LiveHandler(heartbeat *Heartbeat) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subsystems := []SubsystemStatus{}
+		if heartbeat != nil {
+			subsystems = append(subsystems, newSubsystemStatus("scheduler", "", heartbeat.Alive(time.Now())))
+		}
+		writeHealth(w, subsystems)
+	})
+}
+
+func writeHealth(w http.ResponseWriter, subsystems []SubsystemStatus) {
+	code, status := http.StatusOK, "ok"
+	for _, s := range subsystems {
+		if !s.OK {
+			code, status = http.StatusServiceUnavailable, "failing"
+		}
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "subsystems": subsystems})
+}
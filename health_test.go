@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat_Alive(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		start   time.Time
+		last    time.Time
+		cycles  int
+		wantErr bool
+	}{
+		{"starting", now.Add(-2 * time.Minute), time.Time{}, 0, false},
+		{"never_cycled", now.Add(-4 * time.Minute), time.Time{}, 0, true},
+		{"cycling", now.Add(-time.Hour), now.Add(-time.Minute), 10, false},
+		{"wedged", now.Add(-time.Hour), now.Add(-4 * time.Minute), 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHeartbeat(time.Minute, 3)
+			h.start, h.last, h.cycles = tt.start, tt.last, tt.cycles
+			if err := h.Alive(now); (err != nil) != tt.wantErr {
+				t.Errorf("Alive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenManager_Ready(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		granted time.Time
+		expiry  time.Time
+		revoked bool
+		wantErr bool
+	}{
+		{"not_granted", time.Time{}, time.Time{}, false, true},
+		{"granted", now.Add(-time.Minute), now.Add(time.Hour), false, false},
+		{"granted_without_expiry", now.Add(-time.Minute), time.Time{}, false, false},
+		{"expired", now.Add(-2 * time.Hour), now.Add(-time.Hour), false, true},
+		{"revoked", now.Add(-time.Minute), now.Add(time.Hour), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := &TokenManager{granted: tt.granted, expiry: tt.expiry, revoked: tt.revoked}
+			if err := tm.Ready(now); (err != nil) != tt.wantErr {
+				t.Errorf("Ready() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"access_token":"new-token","expires_in":3600}`)
+	}))
+	defer authSvr.Close()
+
+	pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "")
+	handler := ReadyHandler([]*PksMonitor{pks})
+
+	get := func() (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		var body map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body
+	}
+
+	if code, body := get(); code != http.StatusServiceUnavailable || body["status"] != "failing" {
+		t.Errorf("ReadyHandler() before the first grant = %d %v, want 503 failing", code, body)
+	}
+	if err := pks.Tokens().Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if code, body := get(); code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("ReadyHandler() after the first grant = %d %v, want 200 ok", code, body)
+	}
+}
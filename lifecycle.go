@@ -0,0 +1,209 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Lifecycle schedules the checks of the foundations and stops the monitor
+// cleanly. Draining stops running checks, waits for the running ones, runs
+// the drain hooks and revokes the tokens. Shutting down drains, then shuts the
+// http server down.
+type Lifecycle struct {
+	// DrainTimeout bounds how long draining waits for the running checks,
+	// which are cancelled past it.
+	DrainTimeout time.Duration
+	// Server is shut down by Shutdown, when set.
+	Server *http.Server
+
+	monitors  []*PksMonitor
+	interval  time.Duration
+	heartbeat *Heartbeat
+
+	// checksCtx is cancelled when the running checks outlive DrainTimeout
+	checksCtx    context.Context
+	cancelChecks context.CancelFunc
+
+	mu    sync.Mutex
+	hooks []func(ctx context.Context) error
+
+	stop      chan struct{}
+	stopped   chan struct{}
+	drainOnce sync.Once
+	drained   chan struct{}
+}
+
+// NewLifecycle returns the Lifecycle of monitors, checked every interval. Every
+// check cycle beats heartbeat, when not nil. With a zero interval, the checks
+// aren't scheduled, as they run on scrape.
+func NewLifecycle(monitors []*PksMonitor, interval time.Duration, heartbeat *Heartbeat) *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{
+		DrainTimeout: DefaultCheckTimeout,
+		monitors:     monitors,
+		interval:     interval,
+		heartbeat:    heartbeat,
+		checksCtx:    ctx,
+		cancelChecks: cancel,
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		drained:      make(chan struct{}),
+	}
+}
+
+// OnDrain adds a hook run once the checks drained and before the tokens are
+// revoked, to push final metrics or notifications.
+func (l *Lifecycle) OnDrain(hook func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hook)
+}
+
+// Run checks the foundations every interval until ctx is done or the lifecycle
+// is drained. A check cycle runs to completion before the next one starts.
+func (l *Lifecycle) Run(ctx context.Context) {
+	defer close(l.stopped)
+
+	if l.interval <= 0 {
+		select {
+		case <-ctx.Done():
+		case <-l.stop:
+		}
+		return
+	}
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// a drain may have started while waiting for the tick
+		select {
+		case <-l.stop:
+			return
+		default:
+		}
+		l.runChecks()
+		if l.heartbeat != nil {
+			l.heartbeat.Beat()
+		}
+	}
+}
+
+// runChecks runs the checks of every foundation concurrently and waits for them to finish.
+func (l *Lifecycle) runChecks() {
+	var wg sync.WaitGroup
+	for _, m := range l.monitors {
+		wg.Add(1)
+		go func(m *PksMonitor) {
+			defer wg.Done()
+			for _, res := range m.Run(l.checksCtx) {
+				if res.Err != nil {
+					fmt.Printf("pks-monitor: %s check %s failed: %+v\n", res.Foundation, res.Check, res.Err)
+				}
+			}
+		}(m)
+	}
+	wg.Wait()
+}
+
+// Drain stops running checks, waits up to DrainTimeout for the running ones,
+// whether they were scheduled, run on scrape or probed, runs the drain hooks and
+// revokes the tokens. The checks are cancelled past DrainTimeout, or once ctx is
+// done, and aren't waited for after ctx is done. Draining again waits for the
+// first drain to finish, until ctx is done. Run must have been started.
+func (l *Lifecycle) Drain(ctx context.Context) {
+	l.drainOnce.Do(func() {
+		defer close(l.drained)
+		close(l.stop)
+		checksDone := l.checksDone()
+
+		timer := time.NewTimer(l.DrainTimeout)
+		select {
+		case <-checksDone:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+
+		select {
+		case <-checksDone:
+		default:
+			fmt.Println("pks-monitor: checks still running, cancelling them")
+			l.cancelChecks()
+			for _, m := range l.monitors {
+				m.Registry().abortChecks()
+			}
+			select {
+			case <-checksDone:
+			case <-ctx.Done():
+				fmt.Printf("pks-monitor: cancelled checks still running, draining anyway: %v\n", ctx.Err())
+			}
+		}
+		l.cancelChecks()
+
+		l.mu.Lock()
+		hooks := l.hooks
+		l.mu.Unlock()
+		for _, hook := range hooks {
+			if err := hook(ctx); err != nil {
+				fmt.Printf("pks-monitor: drain hook failed: %+v\n", err)
+			}
+		}
+
+		RevokeTokens(l.monitors)
+	})
+	select {
+	case <-l.drained:
+	case <-ctx.Done():
+	}
+}
+
+// checksDone stops running the checks of every foundation and returns a channel
+// closed once the scheduler stopped and the running checks finished, including
+// the ones run on scrape or by a probe.
+func (l *Lifecycle) checksDone() <-chan struct{} {
+	var idle []<-chan struct{}
+	for _, m := range l.monitors {
+		idle = append(idle, m.Registry().drain())
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-l.stopped
+		for _, c := range idle {
+			<-c
+		}
+	}()
+	return done
+}
+
+// Shutdown drains the lifecycle, then shuts the http server down, waiting for
+// its requests until ctx is done.
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.Drain(ctx)
+	if l.Server == nil {
+		return nil
+	}
+	return l.Server.Shutdown(ctx)
+}
+
+// RevokeTokens revokes the access token of every foundation, so no token outlives the pod.
+func RevokeTokens(monitors []*PksMonitor) {
+	for _, m := range monitors {
+		if err := m.Tokens().Revoke(); err != nil {
+			fmt.Printf("pks-monitor: %s: %+v\n", m.Foundation(), err)
+			continue
+		}
+		fmt.Printf("pks-monitor: %s: token revoked\n", m.Foundation())
+	}
+}
@@ -0,0 +1,170 @@
+package monitor
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedCheck runs until it's released or its context is done, unless it ignores
+// its context.
+type gatedCheck struct {
+	started       chan struct{}
+	release       chan struct{}
+	ignoreContext bool
+	runs          int32
+}
+
+func (c *gatedCheck) Name() string { return "gated" }
+
+func (c *gatedCheck) Run(ctx context.Context) Result {
+	atomic.AddInt32(&c.runs, 1)
+	select {
+	case c.started <- struct{}{}:
+	default:
+	}
+	done := ctx.Done()
+	if c.ignoreContext {
+		done = nil
+	}
+	select {
+	case <-c.release:
+		return Result{Up: true}
+	case <-done:
+		return Result{Err: ctx.Err()}
+	}
+}
+
+func TestLifecycle_Drain(t *testing.T) {
+	tests := []struct {
+		name string
+		// scheduled runs the check on the interval, else like a scrape or a probe does
+		scheduled    bool
+		release      bool
+		drainTimeout time.Duration
+		wantUp       bool
+	}{
+		{"waits_for_running_checks", true, true, time.Second, true},
+		{"cancels_checks_past_timeout", true, false, 10 * time.Millisecond, false},
+		{"waits_for_scraped_checks", false, true, time.Second, true},
+		{"cancels_scraped_checks_past_timeout", false, false, 10 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := &gatedCheck{started: make(chan struct{}, 1), release: make(chan struct{})}
+			pks := newTestRegistryMonitor(t, "test", check)
+
+			var heartbeat *Heartbeat
+			interval := time.Duration(0)
+			if tt.scheduled {
+				heartbeat = NewHeartbeat(time.Millisecond, 1)
+				interval = time.Millisecond
+			}
+			l := NewLifecycle([]*PksMonitor{pks}, interval, heartbeat)
+			l.DrainTimeout = tt.drainTimeout
+			var hooked int32
+			l.OnDrain(func(ctx context.Context) error {
+				atomic.AddInt32(&hooked, 1)
+				return nil
+			})
+			go l.Run(context.Background())
+			if !tt.scheduled {
+				go pks.Run(context.Background())
+			}
+			<-check.started
+
+			drained := make(chan struct{})
+			go func() {
+				l.Drain(context.Background())
+				close(drained)
+			}()
+			if tt.release {
+				select {
+				case <-drained:
+					t.Fatal("Drain() returned before the running check finished")
+				case <-time.After(20 * time.Millisecond):
+				}
+				close(check.release)
+			}
+
+			select {
+			case <-drained:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Drain() didn't return")
+			}
+			if last := pks.registry.Last(); len(last) != 1 || last[0].Up != tt.wantUp {
+				t.Errorf("Drain() last result = %+v, want up %t", last, tt.wantUp)
+			}
+			if got := atomic.LoadInt32(&check.runs); got != 1 {
+				t.Errorf("Drain() got %d check runs, want 1", got)
+			}
+			if got := atomic.LoadInt32(&hooked); got != 1 {
+				t.Errorf("Drain() ran the hooks %d times, want 1", got)
+			}
+			if !pks.Tokens().Revoked() {
+				t.Errorf("Drain() didn't revoke the token")
+			}
+
+			// draining again is a no-op
+			l.Drain(context.Background())
+			if got := atomic.LoadInt32(&hooked); got != 1 {
+				t.Errorf("Drain() twice ran the hooks %d times, want 1", got)
+			}
+
+			// no check runs once drained
+			if res := pks.Run(context.Background()); res != nil {
+				t.Errorf("Run() after Drain() = %+v, want no result", res)
+			}
+			if _, ok := pks.Registry().RunCheck(context.Background(), check.Name()); ok {
+				t.Errorf("RunCheck() after Drain() ran the check")
+			}
+		})
+	}
+}
+
+func TestLifecycle_Drain_Context(t *testing.T) {
+	check := &gatedCheck{started: make(chan struct{}, 1), release: make(chan struct{}), ignoreContext: true}
+	defer close(check.release)
+	pks := newTestRegistryMonitor(t, "test", check)
+
+	l := NewLifecycle([]*PksMonitor{pks}, 0, nil)
+	l.DrainTimeout = time.Hour
+	go l.Run(context.Background())
+	go pks.Run(context.Background())
+	<-check.started
+
+	// the check ignores its cancellation, draining stops waiting for it once ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	drained := make(chan struct{})
+	go func() {
+		l.Drain(ctx)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Drain() didn't return once its context was done")
+	}
+	if !pks.Tokens().Revoked() {
+		t.Errorf("Drain() didn't revoke the token")
+	}
+}
+
+func TestLifecycle_Run_NoHeartbeat(t *testing.T) {
+	check := &fakeCheck{name: "scheduled", up: true}
+	pks := newTestRegistryMonitor(t, "test", check)
+
+	l := NewLifecycle([]*PksMonitor{pks}, time.Millisecond, nil)
+	go l.Run(context.Background())
+	defer l.Drain(context.Background())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(pks.Registry().Last()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Run() didn't run the checks")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
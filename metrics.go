@@ -0,0 +1,276 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reservedLabelNames are the label names the metrics already use, which the
+// labels of a target can't reuse. "le" is the bucket label of the histograms.
+var reservedLabelNames = []string{
+	"foundation", "check", "reason", "phase", "le", "scope", "result", "endpoint", "issuer", "subject",
+	"sans", "name", "uuid", "plan", "kubernetes_version", "last_action", "state",
+}
+
+// metrics are the prometheus metrics of a single foundation. Every metric carries
+// the foundation name and the target labels as constant labels, so the metrics of
+// several foundations can be registered side by side.
+type metrics struct {
+	pksApiUp       prometheus.Gauge
+	pksApiFailures *prometheus.CounterVec
+
+	checkUp       *prometheus.GaugeVec
+	checkDuration *prometheus.GaugeVec
+	checkLastRun  *prometheus.GaugeVec
+	checkErrors   *prometheus.CounterVec
+	httpPhase     *prometheus.HistogramVec
+
+	tokenExpiry        prometheus.Gauge
+	tokenRefreshErrors prometheus.Counter
+	tokenScope         *prometheus.GaugeVec
+
+	tokenRevocations *prometheus.CounterVec
+
+	configReloadSuccess     prometheus.Gauge
+	configLastReloadSuccess prometheus.Gauge
+
+	uaaUp                 prometheus.Gauge
+	uaaTokenGrantDuration prometheus.Gauge
+	uaaTokenGrantErrors   *prometheus.CounterVec
+
+	tlsCertExpiry   *prometheus.GaugeVec
+	tlsCertInfo     *prometheus.GaugeVec
+	tlsCertVerified *prometheus.GaugeVec
+	tlsInsecure     prometheus.Gauge
+	certInfo        certInfo
+
+	clusterInfo            *prometheus.GaugeVec
+	clusterLastActionState *prometheus.GaugeVec
+	clusterWorkerInstances *prometheus.GaugeVec
+	clusterMasterIps       *prometheus.GaugeVec
+	clusterSeries          clusterSeries
+
+	collectors []prometheus.Collector
+}
+
+func newMetrics(namespace string, constLabels prometheus.Labels) *metrics {
+	m := &metrics{
+		pksApiUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "pks_api_up",
+			Help:        "Is the Pks Api up?",
+			ConstLabels: constLabels,
+		}),
+		pksApiFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "pks_api_check_failures_total",
+			Help:        "Number of failed calls to the Pks Api by reason.",
+			ConstLabels: constLabels,
+		}, []string{"reason"}),
+
+		checkUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "check_up",
+			Help:        "Did the last run of the check succeed?",
+			ConstLabels: constLabels,
+		}, []string{"check"}),
+		checkDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "check_duration_seconds",
+			Help:        "Duration of the last run of the check.",
+			ConstLabels: constLabels,
+		}, []string{"check"}),
+		checkLastRun: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "last_check_timestamp_seconds",
+			Help:        "Unix time the last run of the check started at.",
+			ConstLabels: constLabels,
+		}, []string{"check"}),
+		checkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "check_errors_total",
+			Help:        "Number of failed runs of the check.",
+			ConstLabels: constLabels,
+		}, []string{"check"}),
+
+		httpPhase: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "http_phase_duration_seconds",
+			Help:        "Duration of the phases (dns, connect, tls, ttfb, total) of the requests made by the check.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"check", "phase"}),
+
+		tokenExpiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "token_expiry_timestamp_seconds",
+			Help:        "Unix time the access token expires at.",
+			ConstLabels: constLabels,
+		}),
+		tokenRefreshErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "token_refresh_errors_total",
+			Help:        "Number of failed access token refreshes.",
+			ConstLabels: constLabels,
+		}),
+		tokenScope: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "token_scope_info",
+			Help:        "Always 1, one series per scope granted to the access token.",
+			ConstLabels: constLabels,
+		}, []string{"scope"}),
+		tokenRevocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "token_revocations_total",
+			Help:        "Number of access token revocations on shutdown by result (success, failure).",
+			ConstLabels: constLabels,
+		}, []string{"result"}),
+		configReloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "config_reload_success",
+			Help:        "1 if the last reload of the credential files succeeded.",
+			ConstLabels: constLabels,
+		}),
+		configLastReloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "config_last_reload_success_timestamp_seconds",
+			Help:        "Unix time of the last successful reload of the credential files.",
+			ConstLabels: constLabels,
+		}),
+
+		uaaUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "uaa_up",
+			Help:        "Are the UAA /healthz and /info endpoints up?",
+			ConstLabels: constLabels,
+		}),
+		uaaTokenGrantDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "uaa_token_grant_duration_seconds",
+			Help:        "Duration of the last client_credentials token grant.",
+			ConstLabels: constLabels,
+		}),
+		uaaTokenGrantErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "uaa_token_grant_errors_total",
+			Help:        "Number of failed client_credentials token grants by reason.",
+			ConstLabels: constLabels,
+		}, []string{"reason"}),
+
+		tlsCertExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "tls_cert_expiry_seconds",
+			Help:        "Unix time the leaf certificate presented by the endpoint expires at.",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		tlsCertInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "tls_cert_info",
+			Help:        "Issuer, subject and SANs of the leaf certificate presented by the endpoint.",
+			ConstLabels: constLabels,
+		}, []string{"endpoint", "issuer", "subject", "sans"}),
+		tlsCertVerified: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "tls_cert_chain_verified",
+			Help:        "Does the chain presented by the endpoint verify against the configured CA?",
+			ConstLabels: constLabels,
+		}, []string{"endpoint"}),
+		tlsInsecure: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "tls_insecure_skip_verify",
+			Help:        "1 when the certificates of the foundation aren't verified.",
+			ConstLabels: constLabels,
+		}),
+		certInfo: certInfo{labels: map[string][]string{}},
+
+		clusterInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "pks_cluster_info",
+			Help:        "Information about a cluster managed by the Pks Api.",
+			ConstLabels: constLabels,
+		}, []string{"name", "uuid", "plan", "kubernetes_version"}),
+		clusterLastActionState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "pks_cluster_last_action_state",
+			Help:        "State of the last action run on the cluster, 1 for the current state.",
+			ConstLabels: constLabels,
+		}, []string{"name", "last_action", "state"}),
+		clusterWorkerInstances: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "pks_cluster_worker_instances",
+			Help:        "Number of worker instances of the cluster.",
+			ConstLabels: constLabels,
+		}, []string{"name"}),
+		clusterMasterIps: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   "opp",
+			Name:        "pks_cluster_master_ips",
+			Help:        "Number of Kubernetes master IPs of the cluster.",
+			ConstLabels: constLabels,
+		}, []string{"name"}),
+	}
+
+	m.collectors = []prometheus.Collector{
+		m.pksApiUp,
+		m.pksApiFailures,
+		m.checkUp,
+		m.checkDuration,
+		m.checkLastRun,
+		m.checkErrors,
+		m.httpPhase,
+		m.tokenExpiry,
+		m.tokenRefreshErrors,
+		m.tokenScope,
+		m.tokenRevocations,
+		m.configReloadSuccess,
+		m.configLastReloadSuccess,
+		m.uaaUp,
+		m.uaaTokenGrantDuration,
+		m.uaaTokenGrantErrors,
+		m.tlsCertExpiry,
+		m.tlsCertInfo,
+		m.tlsCertVerified,
+		m.tlsInsecure,
+		m.clusterInfo,
+		m.clusterLastActionState,
+		m.clusterWorkerInstances,
+		m.clusterMasterIps,
+	}
+	return m
+}
+
+// Describe implements prometheus.Collector.
+func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (m *metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors {
+		c.Collect(ch)
+	}
+}
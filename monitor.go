@@ -1,166 +1,498 @@
 package monitor
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
-	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	pksNet "github.com/pupimvictor/pks-monitor/net"
+	"github.com/pupimvictor/pks-monitor/uaa"
 )
 
 var (
-	pksApiUp = prometheus.NewGauge(prometheus.GaugeOpts{
-		Namespace: "wf",
-		Subsystem: "opp",
-		Name:      "pks_api_up",
-		Help:      "Is the Pks Api up?",
-	})
-
 	pksListClusters = "/v1/clusters"
+
+	errCredentialsRejected = errors.New("pks-monitor: credentials were rejected")
+
+	// RequiredScopes are the scopes the UAA client needs one of to list the clusters of the Pks Api.
+	RequiredScopes = []string{"pks.clusters.manage", "pks.clusters.admin"}
 )
 
-func init() {
-	prometheus.MustRegister(pksApiUp)
+// PksMonitor monitors the Pks Api of a single foundation. It is a
+// prometheus.Collector exporting the metrics of that foundation.
+type PksMonitor struct {
+	target   Target
+	config   *Config
+	metrics  *metrics
+	registry *Registry
+	tokens   *TokenManager
+	// scraper runs the checks on scrape, in ModeScrape
+	scraper *scrapeRunner
+
+	// mu guards client, which is rebuilt when the credentials are reloaded,
+	// clusters, the clusters the Pks Api last listed, and whether the monitor
+	// logged in
+	mu       sync.RWMutex
+	client   *http.Client
+	files    credentialFiles
+	clusters []Cluster
+	loggedIn bool
 }
 
-type PksMonitor struct {
-	config *Config
-	client *http.Client
+// Options tune how a PksMonitor checks its foundation.
+type Options struct {
+	// Namespace of the exported metrics, DefaultMetricNamespace when empty.
+	Namespace string
+	// Checks are the names of the checks to run, all of them when empty.
+	Checks []string
+	// Timeout bounds every check run, when positive.
+	Timeout time.Duration
+	// HistorySize is the number of results kept per check, DefaultHistorySize when not positive.
+	HistorySize int
+	// Mode tells when the checks run, ModeInterval when empty.
+	Mode string
+	// ScrapeMinAge is how long the results are served before a scrape runs the
+	// checks again, in ModeScrape.
+	ScrapeMinAge time.Duration
 }
 
-func NewPksMonitor(api, cliId, cliSecret string) (*PksMonitor, error) {
-	// Create a CA certificate pool and add cert.pem to it
-	caCert, err := ioutil.ReadFile("/etc/pks-monitor/certs/cert.pem")
+func NewPksMonitor(target Target, opts Options) (*PksMonitor, error) {
+	files, err := readCredentialFiles(target)
 	if err != nil {
-		return nil, errors.Wrap(err, "pks-monitor: couldn't read certs")
+		return nil, err
 	}
 
-	//check if URL is properly formatted
-	u, err := url.Parse(api)
+	api, err := endpointURL(target.API, DefaultAPIPort)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "pks-monitor: invalid api of %s", target.Name)
 	}
-	host := u.Host
-	if strings.Contains(host, ":") {
-		var err error
-		host, _, err = net.SplitHostPort(host)
+
+	minTLSVersion, err := target.TLS.minVersion()
+	if err != nil {
+		return nil, errors.Wrapf(err, "pks-monitor: invalid tls min_version of %s", target.Name)
+	}
+
+	config := &Config{
+		API:                 api.String(),
+		CACert:              files.caCert,
+		SkipSSLVerification: target.TLS.InsecureSkipVerify,
+		UaaCliId:            target.UaaCliId,
+		UaaCliSecret:        files.secret,
+		MinTLSVersion:       minTLSVersion,
+		SystemRoots:         target.TLS.SystemRoots,
+		ServerName:          target.TLS.ServerName,
+		PinnedSPKI:          target.TLS.PinnedSPKI,
+		UAAServerName:       target.TLS.UAAServerName,
+		UAAPinnedSPKI:       target.TLS.UAAPinnedSPKI,
+	}
+	if config.SkipSSLVerification {
+		fmt.Printf("pks-monitor: %s: WARNING certificates aren't verified, insecure_skip_verify is set\n", target.Name)
+	}
+
+	if target.UAA != "" {
+		u, err := endpointURL(target.UAA, DefaultUAAPort)
 		if err != nil {
+			return nil, errors.Wrapf(err, "pks-monitor: invalid uaa of %s", target.Name)
+		}
+		config.UAA = u.String()
+	} else {
+		// PKS serves UAA on its default port of the api host
+		candidate := *api
+		candidate.Host = net.JoinHostPort(api.Hostname(), DefaultUAAPort)
+		candidate.Path = ""
+		config.UAA = discoverUaa(config, candidate.String())
+	}
+
+	pksMonitor, err := newPksMonitor(target, config, opts)
+	if err != nil {
+		return nil, err
+	}
+	pksMonitor.files = files
+
+	// when UAA can't be reached, the monitor starts not ready and Login retries in the background
+	if err := pksMonitor.login(); err != nil {
+		if permanentLoginError(err) {
 			return nil, err
 		}
+		fmt.Printf("pks-monitor: %s: couldn't login, retrying in the background: %v\n", target.Name, err)
 	}
 
-	config := &Config{
-		API:                 u.Scheme + "://" + host,
-		CACert:              string(caCert),
-		SkipSSLVerification: true,
-		UaaCliId:            cliId,
-		UaaCliSecret:        cliSecret,
+	fmt.Printf("monitoring %s: %s (uaa %s) - %s\n", target.Name, config.API, config.UAA, time.Now().Format("2006-01-02 15:04:05"))
+
+	return pksMonitor, nil
+}
+
+func newPksMonitor(target Target, config *Config, opts Options) (*PksMonitor, error) {
+	constLabels := prometheus.Labels{"foundation": target.Name}
+	for name, value := range target.Labels {
+		constLabels[name] = value
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultMetricNamespace
+	}
+
+	pks := &PksMonitor{
+		target:  target,
+		config:  config,
+		metrics: newMetrics(opts.Namespace, constLabels),
+	}
+	pks.tokens = newTokenManager(config, pks.metrics)
+	config.OnVerify = pks.recordPeerCertificates
+	pks.metrics.tlsInsecure.Set(boolToFloat(config.SkipSSLVerification))
+
+	client, err := pks.newClient()
+	if err != nil {
+		return nil, err
 	}
+	pks.client = client
+
+	pks.registry = newRegistry(target.Name, pks.metrics, opts.Timeout, opts.HistorySize)
+	for _, c := range []Check{&apiCheck{pks: pks}, &uaaCheck{pks: pks}} {
+		if len(opts.Checks) == 0 || contains(opts.Checks, c.Name()) {
+			_ = pks.registry.Register(c)
+		}
+	}
+	if opts.Mode == ModeScrape {
+		pks.scraper = &scrapeRunner{minAge: opts.ScrapeMinAge, timeout: opts.Timeout, run: func(ctx context.Context) { pks.Run(ctx) }}
+	}
+	return pks, nil
+}
+
+// discoverUaa returns the URL the UAA server at candidate advertises, which may be
+// a load balancer in front of it. It falls back to candidate when discovery fails.
+func discoverUaa(config *Config, candidate string) string {
+	config.UAA = candidate
 
-	err = AuthenticateApi(config)
+	uaaClient, err := CreateUaaClient(config)
 	if err != nil {
-		return nil, errors.Wrap(err, "pks-monitor: couldn't login to pks")
+		return candidate
 	}
-	//fmt.Printf("fresh token: %+v\n", config.AccessToken)
+	u, err := uaaClient.Discover()
+	if err != nil {
+		fmt.Printf("pks-monitor: couldn't discover uaa, using %s: %v\n", candidate, err)
+		return candidate
+	}
+	return u.String()
+}
 
-	client, err := CreateHttpClient(config)
+// newClient creates the http client calling the Pks Api with the current credentials.
+func (pks *PksMonitor) newClient() (*http.Client, error) {
+	client, err := CreateHttpClient(pks.config, pks.tokens)
 	if err != nil {
 		return nil, errors.Wrap(err, "pks-monitor: couldnt't create http client")
 	}
+	client.Transport = pksNet.NewTraceTransport(client.Transport, pks.metrics.observeTimings("pks_api"))
+	return client, nil
+}
+
+func (pks *PksMonitor) httpClient() *http.Client {
+	pks.mu.RLock()
+	defer pks.mu.RUnlock()
+	return pks.client
+}
+
+// Foundation returns the name of the monitored foundation.
+func (pks *PksMonitor) Foundation() string {
+	return pks.target.Name
+}
 
-	pksMonitor := &PksMonitor{
-		client: client,
-		config: config,
+// Tokens returns the manager of this foundation's access token.
+func (pks *PksMonitor) Tokens() *TokenManager {
+	return pks.tokens
+}
+
+// Registry returns the checks run against this foundation.
+func (pks *PksMonitor) Registry() *Registry {
+	return pks.registry
+}
+
+// Run runs every check against this foundation once.
+func (pks *PksMonitor) Run(ctx context.Context) []Result {
+	return pks.registry.Run(ctx)
+}
+
+// Describe implements prometheus.Collector.
+func (pks *PksMonitor) Describe(ch chan<- *prometheus.Desc) {
+	pks.metrics.Describe(ch)
+}
+
+// Collect implements prometheus.Collector. In ModeScrape, it runs the checks
+// first, unless they ran less than ScrapeMinAge ago.
+func (pks *PksMonitor) Collect(ch chan<- prometheus.Metric) {
+	if pks.scraper != nil {
+		pks.scraper.scrape()
 	}
+	pks.metrics.Collect(ch)
+}
 
-	fmt.Printf("monitoring: %s - %s\n", api, time.Now().Format("2006-01-02 15:04:05"))
+// apiCheck lists the clusters through the Pks Api.
+type apiCheck struct {
+	pks *PksMonitor
+	// probe is set on the copy run by probes, which neither updates the metrics
+	// nor the clusters of the foundation
+	probe bool
+}
 
-	return pksMonitor, nil
+func (c *apiCheck) Name() string {
+	return "pks_api"
+}
+
+func (c *apiCheck) isolated() Check {
+	return &apiCheck{pks: c.pks, probe: true}
 }
 
-// CheckAPI will call the Api and set the prometheus metrics accordingly to it's response
-func (pks PksMonitor) CheckAPI() error {
-	ok, err := pks.callApi()
-	if ok {
-		pksApiUp.Set(1.0)
+// Run will call the Api and set the prometheus metrics accordingly to it's response
+func (c *apiCheck) Run(ctx context.Context) Result {
+	start := time.Now()
+	var ok bool
+	var err error
+	if c.probe {
+		ok, err = c.pks.probeApi(ctx)
 	} else {
-		pksApiUp.Set(0.0)
+		ok, err = c.pks.callApi(ctx)
+		if ok {
+			c.pks.metrics.pksApiUp.Set(1.0)
+		} else {
+			c.pks.metrics.pksApiUp.Set(0.0)
+		}
+	}
+
+	res := Result{
+		Up:        ok,
+		Duration:  time.Since(start),
+		Timestamp: start,
 	}
-	fmt.Printf("pks api is up: %t\n", ok)
+	if !ok {
+		res.Reason = FailureReason(err)
+		res.StatusCode = statusCode(err)
+		res.Err = errors.Wrap(err, "pks-monitor: unable to call API")
+		if !c.probe {
+			c.pks.metrics.pksApiFailures.WithLabelValues(res.Reason).Inc()
+		}
+	}
+	return res
+}
 
-	return errors.Wrap(err, "pks-monitor: unable to call API")
+// callApi lists the clusters and records them. When the Api can't be reached or
+// doesn't answer successfully, the returned error tells why through a Failure.
+func (pks *PksMonitor) callApi(ctx context.Context) (bool, error) {
+	clusters, err := pks.listClusters(ctx, pks.httpClient())
+	if err != nil {
+		return false, err
+	}
+	pks.metrics.recordClusters(clusters)
+	pks.setClusters(clusters)
+	return true, nil
 }
 
-func (pks *PksMonitor) callApi() (bool, error) {
+// probeApi lists the clusters like callApi without recording them. Its client
+// has a transport of its own, so neither the request timings nor the certificates
+// are recorded, and it doesn't refresh the access token when it's rejected.
+func (pks *PksMonitor) probeApi(ctx context.Context) (bool, error) {
+	transport, err := pks.config.probeTransport()
+	if err != nil {
+		return false, errors.Wrap(err, "pks-monitor: couldnt't create http client")
+	}
+	client := pksNet.HTTPClient(pksNet.NewAuthTransport(transport, pks.config, nil))
+	if _, err := pks.listClusters(ctx, client); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// listClusters lists the clusters with client.
+func (pks *PksMonitor) listClusters(ctx context.Context, client *http.Client) ([]Cluster, error) {
 	method := "GET"
-	reqUrl := pks.config.API + ":" + APIPort + pksListClusters
+	reqUrl := pks.config.API + pksListClusters
 
 	// create request object
-	req, err := http.NewRequest(method, reqUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, nil)
 	if err != nil {
-		return false, errors.Wrap(err, "pks-monitor: unable to create new request")
+		return nil, errors.Wrap(err, "pks-monitor: unable to create new request")
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type", "application/json")
 
 	// making api request
-	res, err := pks.client.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
-		return false, errors.Wrap(err, "pks-monitor: unable to make API request")
+		return nil, errors.Wrap(err, "pks-monitor: unable to make API request")
 	}
 	defer res.Body.Close()
 
-	// check if api resp error is a expired token and try to reconnect
-	if expired, _ := pksNet.TokenExpired(res); expired {
-		fmt.Println("reauthenticate...")
-		err := AuthenticateApi(pks.config)
-		if err != nil {
-			return false, errors.Wrap(err, "pks-monitor: unable to reauthenticate: %+v\n")
-		}
-		return true, nil
+	// the client already reauthenticated and replayed the request, the new token was rejected as well
+	if expired, err := pksNet.TokenExpired(res); expired && err == nil {
+		return nil, &Failure{Reason: ReasonTokenExpired, StatusCode: res.StatusCode, Err: errors.New("access token rejected after reauthentication")}
 	}
 
 	// check success of api call
 	if res.StatusCode != 200 {
 		fmt.Printf("pks-monitor: PKS API seems to be down - response status code: %d\n", res.StatusCode)
-		return false, nil
+		return nil, statusFailure(res.StatusCode)
 	}
 
-	return true, nil
+	clusters, err := DecodeClusters(res.Body)
+	if err != nil {
+		return nil, &Failure{Reason: ReasonDecodeError, StatusCode: res.StatusCode, Err: err}
+	}
+	return clusters, nil
 }
 
-func AuthenticateApi(c *Config) error {
-	uaaClient, err := CreateUaaClient(c)
+func (pks *PksMonitor) setClusters(clusters []Cluster) {
+	pks.mu.Lock()
+	defer pks.mu.Unlock()
+	pks.clusters = clusters
+}
+
+// Clusters returns the clusters the Pks Api listed on the last successful pks_api check.
+func (pks *PksMonitor) Clusters() []Cluster {
+	pks.mu.RLock()
+	defer pks.mu.RUnlock()
+	return pks.clusters
+}
+
+// login grants a token and verifies it.
+func (pks *PksMonitor) login() error {
+	if err := pks.tokens.Refresh(); err != nil {
+		return errors.Wrapf(err, "pks-monitor: couldn't login to pks %s", pks.Foundation())
+	}
+	if err := pks.verifyToken(pks.config.GetAccessToken()); err != nil {
+		return errors.Wrapf(err, "pks-monitor: invalid token for pks %s", pks.Foundation())
+	}
+
+	pks.mu.Lock()
+	pks.loggedIn = true
+	pks.mu.Unlock()
+	pks.reloaded()
+	return nil
+}
+
+// Login logs in to the foundation when NewPksMonitor couldn't reach UAA. It
+// retries with an exponential backoff until it's logged in or ctx is done, and
+// fails right away when UAA rejects the client or its scopes.
+func (pks *PksMonitor) Login(ctx context.Context) error {
+	backoff := pks.tokens.MinBackoff
+	for !pks.LoggedIn() {
+		err := pks.login()
+		if err == nil {
+			fmt.Printf("pks-monitor: %s: logged in\n", pks.Foundation())
+			return nil
+		}
+		if permanentLoginError(err) {
+			return err
+		}
+		fmt.Printf("pks-monitor: %s: couldn't login, retrying in %s: %v\n", pks.Foundation(), backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > pks.tokens.MaxBackoff {
+			backoff = pks.tokens.MaxBackoff
+		}
+	}
+	return nil
+}
+
+// LoggedIn returns true once a token was granted and verified.
+func (pks *PksMonitor) LoggedIn() bool {
+	pks.mu.RLock()
+	defer pks.mu.RUnlock()
+	return pks.loggedIn
+}
+
+// permanentLoginError tells whether logging in failed because of the client
+// configuration, which retrying won't fix: UAA rejected the client or didn't
+// grant it the required scopes. Other errors, like UAA being unreachable or
+// failing, are transient.
+func permanentLoginError(err error) bool {
+	var respErr *uaa.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.Name != "server_error" && respErr.Name != "temporarily_unavailable"
+	}
+	var scopeErr *scopeError
+	return errors.As(err, &scopeErr) || errors.Is(err, errCredentialsRejected)
+}
+
+// scopeError tells that the UAA client was granted none of RequiredScopes.
+type scopeError struct {
+	clientID string
+}
+
+func (e *scopeError) Error() string {
+	return fmt.Sprintf("pks-monitor: client %s has none of the scopes %s", e.clientID, strings.Join(RequiredScopes, ", "))
+}
+
+// verifyToken checks the signature of token against UAA's token keys
+// and that the client was granted one of RequiredScopes, so a misconfigured
+// client fails at startup rather than on every check.
+func (pks *PksMonitor) verifyToken(token string) error {
+	uaaClient, err := CreateUaaClient(pks.config)
+	if err != nil {
+		return err
+	}
+	claims, err := uaaClient.VerifyToken(token)
 	if err != nil {
 		return err
 	}
 
+	pks.metrics.tokenScope.Reset()
+	for _, scope := range claims.Scope {
+		pks.metrics.tokenScope.WithLabelValues(scope).Set(1)
+	}
+
+	if !claims.HasAnyScope(RequiredScopes...) {
+		return &scopeError{clientID: claims.ClientID}
+	}
+	return nil
+}
+
+// AuthenticateApi gets a new access token from UAA and stores it in c.
+func AuthenticateApi(c *Config) (uaa.Token, error) {
+	token, err := grantToken(c)
+	if err != nil {
+		return token, err
+	}
+	c.SetAccessToken(token.AccessToken)
+	return token, nil
+}
+
+func grantToken(c *Config) (uaa.Token, error) {
+	uaaClient, err := CreateUaaClient(c)
+	if err != nil {
+		return uaa.Token{}, err
+	}
+
 	// request for /actuator/info to setup cookies
 	request, err := http.NewRequest("HEAD", uaaClient.AuthURL.String()+"/actuator/info", nil)
 	if err != nil {
-		return errors.Wrap(err, "Unable to create an HTTPS request.")
+		return uaa.Token{}, errors.Wrap(err, "Unable to create an HTTPS request.")
 	}
 	response, err := uaaClient.Client.Do(request)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("Unable to send a HEAD request to UAA: %s", request.RequestURI))
+		return uaa.Token{}, errors.Wrap(err, fmt.Sprintf("Unable to send a HEAD request to UAA: %s", request.RequestURI))
 	}
+	response.Body.Close()
 	if response.StatusCode == http.StatusUnauthorized {
-		return errors.New("pks-pks-monitor: credentials were rejected")
+		return uaa.Token{}, errCredentialsRejected
 	}
 
 	// call uaa api for access token
-	token, err := uaaClient.ClientCredentialGrant(c.UaaCliId, c.UaaCliSecret)
+	token, err := uaaClient.ClientCredentialGrant(c.UaaCliId, c.GetUaaCliSecret())
 	if err != nil {
-		return errors.Wrap(err, "pks-pks-monitor: couldn't get token")
+		return uaa.Token{}, errors.Wrap(err, "pks-pks-monitor: couldn't get token")
 	}
-
-	c.AccessToken = token.AccessToken
-	return nil
+	return token, nil
 }
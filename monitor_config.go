@@ -0,0 +1,277 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Defaults of the monitor configuration.
+const (
+	DefaultListenAddress   = ":8080"
+	DefaultMetricNamespace = "wf"
+	DefaultCheckInterval   = 30 * time.Second
+	DefaultCheckTimeout    = 10 * time.Second
+	DefaultShutdownTimeout = 20 * time.Second
+)
+
+// Checks lists the names of the checks a foundation can be monitored with.
+var Checks = []string{"pks_api", "uaa"}
+
+// MonitorConfig is the configuration of the monitor, loaded from the file given
+// with --config, like:
+//
+//	listen_address: :8080
+//	metric_namespace: wf
+//	mode: interval
+//	scrape_min_age: 10s
+//	check_interval: 30s
+//	check_timeout: 10s
+//	reload_interval: 30s
+//	shutdown_timeout: 20s
+//	token_refresh_fraction: 0.8
+//	liveness_intervals: 3
+//	history_size: 20
+//	checks: [pks_api, uaa]
+//	wavefront:
+//	  proxy: wavefront-proxy.monitoring:2878
+//	targets:
+//	- name: prod-dc1
+//	  api: https://api.pks.dc1.example.com
+//	  uaa_cli_id: pks-monitor
+//	  uaa_cli_secret_env: PROD_DC1_UAA_CLI_SECRET
+//
+// A target's UAA client credentials are set inline, or read from the environment
+// variable or file they reference.
+type MonitorConfig struct {
+	ListenAddress        string          `yaml:"listen_address"`
+	MetricNamespace      string          `yaml:"metric_namespace"`
+	Mode                 string          `yaml:"mode"`
+	ScrapeMinAge         time.Duration   `yaml:"scrape_min_age"`
+	CheckInterval        time.Duration   `yaml:"check_interval"`
+	CheckTimeout         time.Duration   `yaml:"check_timeout"`
+	ReloadInterval       time.Duration   `yaml:"reload_interval"`
+	ShutdownTimeout      time.Duration   `yaml:"shutdown_timeout"`
+	TokenRefreshFraction float64         `yaml:"token_refresh_fraction"`
+	LivenessIntervals    int             `yaml:"liveness_intervals"`
+	HistorySize          int             `yaml:"history_size"`
+	Checks               []string        `yaml:"checks"`
+	Wavefront            WavefrontConfig `yaml:"wavefront"`
+	Targets              []Target        `yaml:"targets"`
+}
+
+// DefaultMonitorConfig returns the configuration used for the settings a config
+// file leaves out. CheckTimeout is left zero, Validate defaults it to
+// DefaultCheckTimeout or CheckInterval when that's shorter.
+func DefaultMonitorConfig() *MonitorConfig {
+	return &MonitorConfig{
+		ListenAddress:        DefaultListenAddress,
+		MetricNamespace:      DefaultMetricNamespace,
+		Mode:                 ModeInterval,
+		ScrapeMinAge:         DefaultScrapeMinAge,
+		CheckInterval:        DefaultCheckInterval,
+		ReloadInterval:       DefaultReloadInterval,
+		ShutdownTimeout:      DefaultShutdownTimeout,
+		TokenRefreshFraction: DefaultRefreshFraction,
+		LivenessIntervals:    DefaultLivenessIntervals,
+		HistorySize:          DefaultHistorySize,
+		Checks:               append([]string(nil), Checks...),
+		Wavefront: WavefrontConfig{
+			FlushInterval: DefaultWavefrontFlushInterval,
+			BatchSize:     DefaultWavefrontBatchSize,
+		},
+	}
+}
+
+// LoadConfig reads the monitor configuration from a yaml file. The settings the
+// file leaves out keep their default. The configuration isn't validated, as
+// environment variables may still override it.
+func LoadConfig(path string) (*MonitorConfig, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "pks-monitor: couldn't read config file")
+	}
+
+	c := DefaultMonitorConfig()
+	if err := yaml.UnmarshalStrict(buf, c); err != nil {
+		return nil, errors.Wrapf(err, "pks-monitor: couldn't parse config file %s", path)
+	}
+	return c, nil
+}
+
+// ApplyEnv overrides the configuration with the environment variables the monitor
+// was configured with before config files existed:
+//
+//	API_CHECK_INTERVAL_SECS  check_interval, in seconds
+//	TOKEN_REFRESH_FRACTION   token_refresh_fraction
+//	PKS_TARGETS_FILE         targets, read from a targets file
+//	PKS_FOUNDATION, PKS_API, PKS_UAA, UAA_CLI_ID, UAA_CLI_SECRET, UAA_CLI_SECRET_FILE
+//	                         the single target, added when no target is configured
+//	TLS_INSECURE_SKIP_VERIFY tls insecure_skip_verify of every target
+//	WAVEFRONT_PROXY          wavefront proxy
+func (c *MonitorConfig) ApplyEnv(getenv func(string) string) error {
+	if interval := getenv("API_CHECK_INTERVAL_SECS"); interval != "" {
+		secs, err := strconv.Atoi(interval)
+		if err != nil || secs <= 0 {
+			return fmt.Errorf("pks-monitor: API_CHECK_INTERVAL_SECS must be a positive number of seconds, got %q", interval)
+		}
+		c.CheckInterval = time.Duration(secs) * time.Second
+	}
+
+	if fraction := getenv("TOKEN_REFRESH_FRACTION"); fraction != "" {
+		f, err := strconv.ParseFloat(fraction, 64)
+		if err != nil {
+			return fmt.Errorf("pks-monitor: TOKEN_REFRESH_FRACTION must be a number, got %q", fraction)
+		}
+		c.TokenRefreshFraction = f
+	}
+
+	if path := getenv("PKS_TARGETS_FILE"); path != "" {
+		targets, err := LoadTargets(path)
+		if err != nil {
+			return err
+		}
+		c.Targets = targets
+	}
+
+	overrideString(&c.Wavefront.Proxy, getenv("WAVEFRONT_PROXY"))
+
+	if err := c.applyEnvTarget(getenv); err != nil {
+		return err
+	}
+
+	if insecure := getenv("TLS_INSECURE_SKIP_VERIFY"); insecure != "" {
+		skip, err := strconv.ParseBool(insecure)
+		if err != nil {
+			return fmt.Errorf("pks-monitor: TLS_INSECURE_SKIP_VERIFY must be true or false, got %q", insecure)
+		}
+		for i := range c.Targets {
+			c.Targets[i].TLS.InsecureSkipVerify = skip
+		}
+	}
+	return nil
+}
+
+// applyEnvTarget adds the target configured by the environment, or overrides the single target with it.
+func (c *MonitorConfig) applyEnvTarget(getenv func(string) string) error {
+	env := Target{
+		Name:             getenv("PKS_FOUNDATION"),
+		API:              getenv("PKS_API"),
+		UAA:              getenv("PKS_UAA"),
+		UaaCliId:         getenv("UAA_CLI_ID"),
+		UaaCliSecret:     getenv("UAA_CLI_SECRET"),
+		UaaCliSecretFile: getenv("UAA_CLI_SECRET_FILE"),
+	}
+	if env.API == "" && env.UAA == "" && env.UaaCliId == "" && env.UaaCliSecret == "" && env.UaaCliSecretFile == "" {
+		return nil
+	}
+	switch len(c.Targets) {
+	case 0:
+		if env.Name == "" {
+			env.Name = "default"
+		}
+		c.Targets = []Target{env}
+	case 1:
+		t := &c.Targets[0]
+		overrideString(&t.Name, env.Name)
+		overrideString(&t.API, env.API)
+		overrideString(&t.UAA, env.UAA)
+		overrideString(&t.UaaCliId, env.UaaCliId)
+		if env.UaaCliSecret != "" || env.UaaCliSecretFile != "" {
+			t.UaaCliSecret, t.UaaCliSecretEnv, t.UaaCliSecretFile = env.UaaCliSecret, "", env.UaaCliSecretFile
+		}
+	default:
+		return errors.New("pks-monitor: PKS_API, UAA_CLI_ID and UAA_CLI_SECRET can't override a config with several targets")
+	}
+	return nil
+}
+
+func overrideString(s *string, value string) {
+	if value != "" {
+		*s = value
+	}
+}
+
+// Validate checks the configuration, then resolves the credentials of the
+// targets referencing environment variables with getenv. A check_timeout left
+// out defaults to DefaultCheckTimeout, bounded by check_interval, so a short
+// API_CHECK_INTERVAL_SECS doesn't need a check_timeout.
+func (c *MonitorConfig) Validate(getenv func(string) string) error {
+	if c.CheckTimeout == 0 {
+		c.CheckTimeout = DefaultCheckTimeout
+		if c.CheckInterval < c.CheckTimeout {
+			c.CheckTimeout = c.CheckInterval
+		}
+	}
+
+	switch {
+	case c.ListenAddress == "":
+		return errors.New("pks-monitor: listen_address is required")
+	case !labelNameRE.MatchString(c.MetricNamespace):
+		return fmt.Errorf("pks-monitor: metric_namespace %q is not a valid metric name", c.MetricNamespace)
+	case c.Mode != ModeInterval && c.Mode != ModeScrape:
+		return fmt.Errorf("pks-monitor: mode must be %s or %s, got %q", ModeInterval, ModeScrape, c.Mode)
+	case c.ScrapeMinAge < 0:
+		return fmt.Errorf("pks-monitor: scrape_min_age can't be negative, got %s", c.ScrapeMinAge)
+	case c.CheckInterval < time.Second:
+		return durationError("check_interval", c.CheckInterval)
+	case c.CheckTimeout < time.Second:
+		return durationError("check_timeout", c.CheckTimeout)
+	case c.CheckTimeout > c.CheckInterval:
+		return fmt.Errorf("pks-monitor: check_timeout %s is longer than check_interval %s", c.CheckTimeout, c.CheckInterval)
+	case c.ReloadInterval < time.Second:
+		return durationError("reload_interval", c.ReloadInterval)
+	case c.ShutdownTimeout < time.Second:
+		return durationError("shutdown_timeout", c.ShutdownTimeout)
+	case c.TokenRefreshFraction <= 0 || c.TokenRefreshFraction >= 1:
+		return fmt.Errorf("pks-monitor: token_refresh_fraction must be between 0 and 1, got %v", c.TokenRefreshFraction)
+	case c.LivenessIntervals < 1:
+		return fmt.Errorf("pks-monitor: liveness_intervals must be at least 1, got %d", c.LivenessIntervals)
+	case c.HistorySize < 1:
+		return fmt.Errorf("pks-monitor: history_size must be at least 1, got %d", c.HistorySize)
+	case len(c.Checks) == 0:
+		return errors.New("pks-monitor: checks must enable at least one check")
+	}
+
+	for _, name := range c.Checks {
+		if !contains(Checks, name) {
+			return fmt.Errorf("pks-monitor: unknown check %q, checks are %v", name, Checks)
+		}
+	}
+	if err := c.Wavefront.Validate(); err != nil {
+		return err
+	}
+
+	for i := range c.Targets {
+		if err := c.Targets[i].resolveEnv(getenv); err != nil {
+			return errors.Wrapf(err, "pks-monitor: invalid target #%d", i+1)
+		}
+	}
+	if err := ValidateTargets(c.Targets); err != nil {
+		return err
+	}
+	NormalizeLabels(c.Targets)
+	return nil
+}
+
+// durationError tells that a duration is too short. yaml reads a number without
+// unit as nanoseconds, so it's the likely mistake.
+func durationError(name string, d time.Duration) error {
+	return fmt.Errorf("pks-monitor: %s must be at least 1s, got %s (durations need a unit, like 30s)", name, d)
+}
+
+// Options returns the options of the monitors of the targets.
+func (c *MonitorConfig) Options() Options {
+	return Options{
+		Namespace:    c.MetricNamespace,
+		Checks:       c.Checks,
+		Timeout:      c.CheckTimeout,
+		HistorySize:  c.HistorySize,
+		Mode:         c.Mode,
+		ScrapeMinAge: c.ScrapeMinAge,
+	}
+}
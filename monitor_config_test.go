@@ -0,0 +1,259 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		want    func(*MonitorConfig) string
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			want: func(c *MonitorConfig) string {
+				if c.ListenAddress != ":8080" || c.MetricNamespace != "wf" || c.CheckInterval != 30*time.Second ||
+					c.CheckTimeout != 10*time.Second || len(c.Checks) != 2 {
+					return "defaults not applied"
+				}
+				return ""
+			},
+		},
+		{
+			name: "full",
+			file: `
+listen_address: :9090
+metric_namespace: pks
+check_interval: 1m
+check_timeout: 20s
+reload_interval: 10s
+token_refresh_fraction: 0.5
+checks: [uaa]
+targets:
+- name: sandbox
+  api: https://a.example.com
+  uaa_cli_id: id
+  uaa_cli_secret_env: TEST_UAA_CLI_SECRET
+`,
+			env: map[string]string{"TEST_UAA_CLI_SECRET": "secret-from-env"},
+			want: func(c *MonitorConfig) string {
+				if c.ListenAddress != ":9090" || c.MetricNamespace != "pks" || c.CheckInterval != time.Minute ||
+					c.CheckTimeout != 20*time.Second || c.ReloadInterval != 10*time.Second || c.TokenRefreshFraction != 0.5 {
+					return "settings not loaded"
+				}
+				if len(c.Checks) != 1 || c.Checks[0] != "uaa" {
+					return "checks not loaded"
+				}
+				if c.Targets[0].UaaCliSecret != "secret-from-env" {
+					return "uaa_cli_secret_env not resolved"
+				}
+				return ""
+			},
+		},
+		{
+			name: "env_overrides",
+			file: `
+check_interval: 1m
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			env: map[string]string{"API_CHECK_INTERVAL_SECS": "15", "PKS_API": "https://b.example.com", "UAA_CLI_SECRET_FILE": "/secret"},
+			want: func(c *MonitorConfig) string {
+				if c.CheckInterval != 15*time.Second {
+					return "API_CHECK_INTERVAL_SECS not applied"
+				}
+				if tgt := c.Targets[0]; tgt.API != "https://b.example.com" || tgt.UaaCliSecret != "" || tgt.UaaCliSecretFile != "/secret" {
+					return "target not overridden"
+				}
+				return ""
+			},
+		},
+		{
+			name: "env_only",
+			file: ``,
+			env:  map[string]string{"PKS_API": "https://a.example.com", "UAA_CLI_ID": "id", "UAA_CLI_SECRET": "secret"},
+			want: func(c *MonitorConfig) string {
+				if len(c.Targets) != 1 || c.Targets[0].Name != "default" {
+					return "default target not added"
+				}
+				return ""
+			},
+		},
+		{
+			name: "env_short_interval",
+			file: ``,
+			env:  map[string]string{"API_CHECK_INTERVAL_SECS": "5", "PKS_API": "https://a.example.com", "UAA_CLI_ID": "id", "UAA_CLI_SECRET": "secret"},
+			want: func(c *MonitorConfig) string {
+				if c.CheckInterval != 5*time.Second || c.CheckTimeout != 5*time.Second {
+					return "check_timeout not bounded by API_CHECK_INTERVAL_SECS"
+				}
+				return ""
+			},
+		},
+		{
+			name: "env_insecure",
+			file: `
+targets:
+- {name: a, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+- {name: b, api: https://b.example.com, uaa_cli_id: id, uaa_cli_secret: secret, tls: {insecure_skip_verify: true}}
+`,
+			env: map[string]string{"TLS_INSECURE_SKIP_VERIFY": "true"},
+			want: func(c *MonitorConfig) string {
+				if !c.Targets[0].TLS.InsecureSkipVerify || !c.Targets[1].TLS.InsecureSkipVerify {
+					return "TLS_INSECURE_SKIP_VERIFY not applied"
+				}
+				return ""
+			},
+		},
+		{
+			name:    "unknown_field",
+			file:    `check_intervall: 1m`,
+			wantErr: "field check_intervall not found",
+		},
+		{
+			name: "duration_without_unit",
+			file: `
+check_interval: 30
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			wantErr: "check_interval must be at least 1s, got 30ns",
+		},
+		{
+			name: "timeout_longer_than_interval",
+			file: `
+check_interval: 10s
+check_timeout: 20s
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			wantErr: "check_timeout 20s is longer than check_interval 10s",
+		},
+		{
+			name: "unknown_check",
+			file: `
+checks: [pks_api, bosh]
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			wantErr: `unknown check "bosh"`,
+		},
+		{
+			name: "invalid_mode",
+			file: `
+mode: push
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			wantErr: `mode must be interval or scrape, got "push"`,
+		},
+		{
+			name: "wavefront",
+			file: `
+wavefront:
+  proxy: wavefront-proxy:2878
+  tags: {env: prod}
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			want: func(c *MonitorConfig) string {
+				if c.Wavefront.Proxy != "wavefront-proxy:2878" || c.Wavefront.Tags["env"] != "prod" {
+					return "wavefront not loaded"
+				}
+				if c.Wavefront.FlushInterval != DefaultWavefrontFlushInterval || c.Wavefront.BatchSize != DefaultWavefrontBatchSize {
+					return "wavefront defaults not kept"
+				}
+				return ""
+			},
+		},
+		{
+			name: "invalid_wavefront_proxy",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			env:     map[string]string{"WAVEFRONT_PROXY": "wavefront-proxy"},
+			wantErr: `wavefront proxy must be a host:port, got "wavefront-proxy"`,
+		},
+		{
+			name: "invalid_namespace",
+			file: `
+metric_namespace: wf-opp
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			wantErr: `metric_namespace "wf-opp" is not a valid metric name`,
+		},
+		{
+			name: "several_secrets",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret, uaa_cli_secret_file: /secret}
+`,
+			wantErr: "sandbox: only one of uaa_cli_secret, uaa_cli_secret_env and uaa_cli_secret_file can be set",
+		},
+		{
+			name: "empty_secret_env",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret_env: TEST_MISSING_SECRET}
+`,
+			wantErr: "sandbox: environment variable TEST_MISSING_SECRET of uaa_cli_secret_env is empty",
+		},
+		{
+			name: "env_with_several_targets",
+			file: `
+targets:
+- {name: a, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+- {name: b, api: https://b.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			env:     map[string]string{"PKS_API": "https://c.example.com"},
+			wantErr: "can't override a config with several targets",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "config.yml")
+			if err := ioutil.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := LoadConfig(path)
+			if err == nil {
+				err = c.ApplyEnv(func(key string) string { return tt.env[key] })
+			}
+			if err == nil {
+				err = c.Validate(func(key string) string { return tt.env[key] })
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if msg := tt.want(c); msg != "" {
+				t.Errorf("LoadConfig() %s: %+v", msg, c)
+			}
+		})
+	}
+}
@@ -1,21 +1,116 @@
 package monitor
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/pupimvictor/pks-monitor/uaa"
 )
 
+const clustersResp = `[
+  {
+    "name": "cluster-1",
+    "plan_name": "small",
+    "last_action": "CREATE",
+    "last_action_state": "succeeded",
+    "last_action_description": "Instance provisioning completed",
+    "uuid": "5c9ea5a4-2cb1-4c1b-a8e8-2f3e2e64d1b8",
+    "k8s_version": "1.15.5",
+    "kubernetes_master_ips": ["10.0.0.10", "10.0.0.11", "10.0.0.12"],
+    "parameters": {
+      "kubernetes_master_host": "cluster-1.pks.example.com",
+      "kubernetes_master_port": 8443,
+      "kubernetes_worker_instances": 3
+    }
+  }
+]`
+
+// newTestMonitor points a PksMonitor to the api and uaa test servers.
+func newTestMonitor(t *testing.T, apiURL, uaaURL, accessToken string) *PksMonitor {
+	config := &Config{
+		API:                 apiURL,
+		UAA:                 uaaURL,
+		SkipSSLVerification: true,
+		UaaCliId:            "fakeId",
+		UaaCliSecret:        "fakeSecret",
+	}
+	config.SetAccessToken(accessToken)
+	pks, err := newPksMonitor(Target{Name: "test"}, config, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pks
+}
+
+// newTestRegistryMonitor returns a monitor of foundation running checks only.
+func newTestRegistryMonitor(t *testing.T, foundation string, checks ...Check) *PksMonitor {
+	pks := newTestMonitor(t, "https://127.0.0.1:9021", "https://127.0.0.1:8443", "")
+	pks.target.Name = foundation
+	pks.registry = newRegistry(foundation, pks.metrics, 0, 0)
+	if err := pks.registry.Register(checks...); err != nil {
+		t.Fatal(err)
+	}
+	return pks
+}
+
+// gaugeValue returns the value of the gauge collected by c matching name and labels.
+func gaugeValue(t *testing.T, c prometheus.Collector, name string, labels map[string]string) (float64, bool) {
+	m, ok := findMetric(t, c, name, labels)
+	return m.GetGauge().GetValue(), ok
+}
+
+// counterValue returns the value of the counter collected by c matching name and labels.
+func counterValue(t *testing.T, c prometheus.Collector, name string, labels map[string]string) (float64, bool) {
+	m, ok := findMetric(t, c, name, labels)
+	return m.GetCounter().GetValue(), ok
+}
+
+func findMetric(t *testing.T, c prometheus.Collector, name string, labels map[string]string) (*dto.Metric, bool) {
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v != l.GetValue() {
+					continue metrics
+				}
+			}
+			return m, true
+		}
+	}
+	return nil, false
+}
+
 func TestPksMonitor_callApi(t *testing.T) {
 	type fields struct {
-		apiAddress  string
 		accessToken string
-		apiUri      string
+		resp        string
 		respCode    int
-		client      *http.Client
 	}
 	tests := []struct {
 		name    string
@@ -26,8 +121,8 @@ func TestPksMonitor_callApi(t *testing.T) {
 		{
 			name: "ok",
 			fields: fields{
-				apiAddress:  "localhost",
 				accessToken: "fakeToken",
+				resp:        clustersResp,
 				respCode:    200,
 			},
 			want:    true,
@@ -36,28 +131,34 @@ func TestPksMonitor_callApi(t *testing.T) {
 		{
 			name: "not_ok",
 			fields: fields{
-				apiAddress:  "localhost",
 				accessToken: "fakeToken",
+				resp:        `{"error":"internal_error"}`,
 				respCode:    500,
 			},
 			want:    false,
-			wantErr: false,
+			wantErr: true,
+		},
+		{
+			name: "invalid_body",
+			fields: fields{
+				accessToken: "fakeToken",
+				resp:        "hello, client",
+				respCode:    200,
+			},
+			want:    false,
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(tt.fields.respCode)
-				fmt.Fprintln(w, "hello, client")
+				fmt.Fprintln(w, tt.fields.resp)
 			}))
+			defer svr.Close()
 
-			pks := PksMonitor{
-				pksApiAddr:  svr.URL,
-				authApiAddr: svr.URL,
-				accessToken: tt.fields.accessToken,
-				client:      svr.Client(),
-			}
-			got, err := pks.callApi()
+			pks := newTestMonitor(t, svr.URL, "", tt.fields.accessToken)
+			got, err := pks.callApi(context.Background())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("callApi() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -69,72 +170,141 @@ func TestPksMonitor_callApi(t *testing.T) {
 	}
 }
 
+func TestPksMonitor_Run_Clusters(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, clustersResp)
+	}))
+	defer svr.Close()
+
+	pks := newTestMonitor(t, svr.URL, "", "fakeToken")
+	if res := (&apiCheck{pks: pks}).Run(context.Background()); !res.Up {
+		t.Fatalf("Run() got = %+v, want pks_api up", res)
+	}
+
+	tests := []struct {
+		metric string
+		labels map[string]string
+		want   float64
+	}{
+		{"wf_opp_pks_api_up", map[string]string{"foundation": "test"}, 1},
+		{"wf_opp_pks_cluster_info", map[string]string{"foundation": "test", "name": "cluster-1", "plan": "small", "kubernetes_version": "1.15.5"}, 1},
+		{"wf_opp_pks_cluster_last_action_state", map[string]string{"name": "cluster-1", "state": "succeeded"}, 1},
+		{"wf_opp_pks_cluster_last_action_state", map[string]string{"name": "cluster-1", "state": "failed"}, 0},
+		{"wf_opp_pks_cluster_worker_instances", map[string]string{"name": "cluster-1"}, 3},
+		{"wf_opp_pks_cluster_master_ips", map[string]string{"name": "cluster-1"}, 3},
+	}
+	for _, tt := range tests {
+		got, ok := gaugeValue(t, pks, tt.metric, tt.labels)
+		if !ok {
+			t.Errorf("%s%v not found", tt.metric, tt.labels)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.metric, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestMetrics_recordClusters(t *testing.T) {
+	m := newMetrics(DefaultMetricNamespace, nil)
+	cluster := func(name, state string) Cluster {
+		return Cluster{Name: name, UUID: name, PlanName: "small", LastAction: "CREATE", LastActionState: state}
+	}
+
+	m.recordClusters([]Cluster{cluster("cluster-1", "in progress"), cluster("cluster-2", "succeeded")})
+	m.recordClusters([]Cluster{cluster("cluster-1", "succeeded")})
+
+	tests := []struct {
+		metric string
+		labels map[string]string
+		want   float64
+		found  bool
+	}{
+		{"wf_opp_pks_cluster_info", map[string]string{"name": "cluster-1"}, 1, true},
+		{"wf_opp_pks_cluster_last_action_state", map[string]string{"name": "cluster-1", "state": "succeeded"}, 1, true},
+		{"wf_opp_pks_cluster_last_action_state", map[string]string{"name": "cluster-1", "state": "in progress"}, 0, true},
+		{"wf_opp_pks_cluster_info", map[string]string{"name": "cluster-2"}, 0, false},
+		{"wf_opp_pks_cluster_last_action_state", map[string]string{"name": "cluster-2"}, 0, false},
+		{"wf_opp_pks_cluster_worker_instances", map[string]string{"name": "cluster-2"}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := gaugeValue(t, m, tt.metric, tt.labels)
+		if ok != tt.found || got != tt.want {
+			t.Errorf("%s%v = %v (found %v), want %v (found %v)", tt.metric, tt.labels, got, ok, tt.want, tt.found)
+		}
+	}
+}
+
 func TestPksMonitor_callApi_Reauthenticate(t *testing.T) {
 	type fields struct {
-		apiAddress  string
 		accessToken string
-		apiUri      string
-		token       token
-		respCode    int
-		client      *http.Client
+		token       uaa.Token
 	}
 	tests := []struct {
 		name            string
 		fields          fields
 		wantAccessToken string
+		wantUp          bool
 		wantErr         bool
 	}{
 		{
 			name: "reauthenticate",
 			fields: fields{
-				apiAddress:  "localhost",
 				accessToken: "fakeToken",
-				token: token{
+				token: uaa.Token{
 					AccessToken: "faketoken2",
 					TokenType:   "type",
 					ExpiresIn:   600,
 					Scope:       "scope",
 					Jti:         "faketoken2",
 				},
-				respCode: 401,
 			},
 			wantAccessToken: "faketoken2",
+			wantUp:          true,
 			wantErr:         false,
 		},
+		{
+			name: "rejected_after_reauthenticate",
+			fields: fields{
+				accessToken: "fakeToken",
+				token: uaa.Token{
+					AccessToken: "revokedtoken",
+					ExpiresIn:   600,
+				},
+			},
+			wantAccessToken: "revokedtoken",
+			wantUp:          false,
+			wantErr:         true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				t := r.Header.Get("Authorization")
-				if tt.name == "reauthenticate" {
-					if strings.Contains(t, "faketoken2") {
-						w.WriteHeader(200)
-					} else {
-						w.WriteHeader(401)
-					}
-				} else {
-					w.WriteHeader(tt.fields.respCode)
+				if strings.Contains(r.Header.Get("Authorization"), "faketoken2") {
+					fmt.Fprintln(w, clustersResp)
+					return
 				}
-				fmt.Fprintln(w, "hello, client")
+				w.WriteHeader(401)
+				fmt.Fprintln(w, `{"error":"invalid_token"}`)
 			}))
+			defer svr.Close()
 			authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(200)
 				jsonToken, _ := json.Marshal(tt.fields.token)
 				fmt.Fprintln(w, string(jsonToken))
 			}))
+			defer authSvr.Close()
 
-			pks := PksMonitor{
-				pksApiAddr:  svr.URL,
-				authApiAddr: authSvr.URL,
-				accessToken: tt.fields.accessToken,
-				client:      svr.Client(),
-			}
-			_, err := pks.callApi()
-			gotToken := pks.accessToken
+			pks := newTestMonitor(t, svr.URL, authSvr.URL, tt.fields.accessToken)
+			ok, err := pks.callApi(context.Background())
+			gotToken := pks.config.GetAccessToken()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("callApi() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if ok != tt.wantUp {
+				t.Errorf("callApi() got = %v, want %v", ok, tt.wantUp)
+			}
 			if gotToken != tt.wantAccessToken {
 				t.Errorf("callApi() got = %v, want %v", gotToken, tt.wantAccessToken)
 			}
@@ -142,13 +312,10 @@ func TestPksMonitor_callApi_Reauthenticate(t *testing.T) {
 	}
 }
 
-func TestPksMonitor_authenticateApi(t *testing.T) {
+func TestAuthenticateApi(t *testing.T) {
 	type fields struct {
-		apiAddress   string
-		apiUri       string
 		uaaCliId     string
 		uaaCliSecret string
-		client       *http.Client
 		resp         string
 		respCode     int
 	}
@@ -161,7 +328,6 @@ func TestPksMonitor_authenticateApi(t *testing.T) {
 		{
 			name: "ok",
 			fields: fields{
-				apiAddress:   "localhost",
 				uaaCliId:     "fakeId",
 				uaaCliSecret: "fakeSecret",
 				respCode:     200,
@@ -173,7 +339,6 @@ func TestPksMonitor_authenticateApi(t *testing.T) {
 		{
 			name: "not_ok",
 			fields: fields{
-				apiAddress:   "localhost",
 				uaaCliId:     "fakeId",
 				uaaCliSecret: "fakeSecret",
 				resp:         `{ "error":"Bad Credentials"}`,
@@ -189,23 +354,211 @@ func TestPksMonitor_authenticateApi(t *testing.T) {
 				w.WriteHeader(tt.fields.respCode)
 				fmt.Fprintln(w, tt.fields.resp)
 			}))
+			defer svr.Close()
+
+			pks := newTestMonitor(t, svr.URL, svr.URL, "")
+			pks.config.UaaCliId = tt.fields.uaaCliId
+			pks.config.UaaCliSecret = tt.fields.uaaCliSecret
+
+			_, err := AuthenticateApi(pks.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AuthenticateApi() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := pks.config.GetAccessToken(); got != tt.want {
+				t.Errorf("AuthenticateApi() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// signedToken returns a JWT granting scopes, signed with key as UAA would.
+func signedToken(t *testing.T, key *rsa.PrivateKey, scopes []string) string {
+	encode := func(v interface{}) string {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": "key-1"}) + "." + encode(map[string]interface{}{
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"scope":     scopes,
+		"client_id": "fakeId",
+	})
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writeTokenKeys answers UAA's /token_keys with the public part of key.
+func writeTokenKeys(w http.ResponseWriter, key *rsa.PrivateKey) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": []uaa.TokenKey{{
+		Kid: "key-1",
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+}
+
+func TestPksMonitor_verifyToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		scopes  []string
+		wantErr bool
+	}{
+		{
+			name:   "admin",
+			scopes: []string{"pks.clusters.admin", "uaa.none"},
+		},
+		{
+			name:   "manage",
+			scopes: []string{"pks.clusters.manage"},
+		},
+		{
+			name:    "missing_scope",
+			scopes:  []string{"uaa.none"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]interface{}{"keys": []uaa.TokenKey{{
+					Kid: "key-1",
+					Kty: "RSA",
+					N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}}})
+			}))
+			defer svr.Close()
+
+			pks := newTestMonitor(t, svr.URL, svr.URL, signedToken(t, key, tt.scopes))
 
-			pks := PksMonitor{
-				pksApiAddr:   svr.URL,
-				authApiAddr:  svr.URL,
-				client:       svr.Client(),
-				uaaCliSecret: tt.fields.uaaCliSecret,
-				uaaCliId:     tt.fields.uaaCliId,
+			err := pks.verifyToken(pks.config.GetAccessToken())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, scope := range tt.scopes {
+				if got, ok := gaugeValue(t, pks, "wf_opp_token_scope_info", map[string]string{"scope": scope}); !ok || got != 1 {
+					t.Errorf("token_scope_info{scope=%q} = %v, %v, want 1", scope, got, ok)
+				}
 			}
-			got, err := pks.authenticateApi()
+		})
+	}
+}
+
+func TestNewPksMonitor_Login(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		scopes  []string
+		grant   func(n int) (int, string)
+		wantErr bool
+	}{
+		{
+			name:   "uaa_unavailable_at_start",
+			scopes: []string{"pks.clusters.admin"},
+			grant: func(n int) (int, string) {
+				if n <= 2 {
+					return 503, "Service Unavailable"
+				}
+				return 200, ""
+			},
+		},
+		{
+			name:    "client_rejected",
+			scopes:  []string{"pks.clusters.admin"},
+			grant:   func(int) (int, string) { return 401, `{"error":"unauthorized","error_description":"Bad credentials"}` },
+			wantErr: true,
+		},
+		{
+			name:    "missing_scope",
+			scopes:  []string{"uaa.none"},
+			grant:   func(int) (int, string) { return 200, "" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var grants int
+			var mu sync.Mutex
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/oauth/token":
+					mu.Lock()
+					grants++
+					code, body := tt.grant(grants)
+					mu.Unlock()
+					if body == "" {
+						body = fmt.Sprintf(`{"access_token":%q,"expires_in":3600}`, signedToken(t, key, tt.scopes))
+					}
+					w.WriteHeader(code)
+					fmt.Fprintln(w, body)
+				case "/token_keys":
+					json.NewEncoder(w).Encode(map[string]interface{}{"keys": []uaa.TokenKey{{
+						Kid: "key-1",
+						Kty: "RSA",
+						N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+						E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+					}}})
+				}
+			}))
+			defer svr.Close()
+
+			target := Target{Name: "test", API: svr.URL, UAA: svr.URL, UaaCliId: "id", UaaCliSecret: "secret", TLS: TargetTLS{InsecureSkipVerify: true}}
+			pks, err := NewPksMonitor(target, Options{})
 			if (err != nil) != tt.wantErr {
-				t.Errorf("callApi() error = %v, wantErr %v", err, tt.wantErr)
+				t.Fatalf("NewPksMonitor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
 				return
 			}
-			if got != tt.want {
-				t.Errorf("callApi() got = %v, want %v", got, tt.want)
+
+			// the monitor starts, but isn't ready until it logs in
+			if pks.LoggedIn() || pks.Tokens().Ready(time.Now()) == nil {
+				t.Fatalf("NewPksMonitor() with UAA unavailable is ready, want not ready")
+			}
+			pks.Tokens().MinBackoff = 10 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := pks.Login(ctx); err != nil {
+				t.Fatalf("Login() error = %v", err)
+			}
+			if err := pks.Tokens().Ready(time.Now()); err != nil || !pks.LoggedIn() {
+				t.Errorf("Login() left the monitor not ready: %v", err)
 			}
-			svr.Close()
 		})
 	}
 }
+
+func TestPksMonitor_Run_Timings(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, clustersResp)
+	}))
+	defer svr.Close()
+
+	pks := newTestMonitor(t, svr.URL, "", "fakeToken")
+	if res := (&apiCheck{pks: pks}).Run(context.Background()); !res.Up {
+		t.Fatalf("Run() got = %+v, want pks_api up", res)
+	}
+
+	for _, phase := range []string{"connect", "tls", "ttfb", "total"} {
+		m, ok := findMetric(t, pks.metrics.httpPhase, "wf_opp_http_phase_duration_seconds", map[string]string{"check": "pks_api", "phase": phase})
+		if !ok || m.GetHistogram().GetSampleCount() != 1 {
+			t.Errorf("wf_opp_http_phase_duration_seconds{phase=%q} got %v, want 1 sample", phase, m)
+		}
+	}
+}
@@ -9,13 +9,13 @@ import (
 
 //go:generate counterfeiter net/http.RoundTripper
 
+// AuthTransport authorizes requests with the access token of the TokenStore.
+// When the server rejects the token as expired, a new token is fetched from
+// the TokenSource and the request is replayed once.
 type AuthTransport struct {
-	Transport      http.RoundTripper
-	tokenStore     TokenStore
-
-	//todo check if cliId and secret are necessary here
-	clientID       string
-	clientSecret   string
+	Transport   http.RoundTripper
+	tokenStore  TokenStore
+	tokenSource TokenSource
 }
 
 //go:generate counterfeiter . TokenStore
@@ -25,25 +25,79 @@ type TokenStore interface {
 	SetAccessToken(string)
 }
 
-func NewAuthTransport(rt http.RoundTripper, ts TokenStore, clientID, clientSecret string) *AuthTransport {
+//go:generate counterfeiter . TokenSource
+
+// TokenSource gets a new access token when the current one expired.
+type TokenSource interface {
+	FetchToken() (string, error)
+}
+
+// TokenRefreshError is returned when an expired token couldn't be replaced.
+type TokenRefreshError struct {
+	Err error
+}
+
+func (e *TokenRefreshError) Error() string {
+	return "net: couldn't refresh expired token: " + e.Err.Error()
+}
+
+func (e *TokenRefreshError) Unwrap() error {
+	return e.Err
+}
+
+// NewAuthTransport creates an AuthTransport. A nil TokenSource disables reauthentication.
+func NewAuthTransport(rt http.RoundTripper, ts TokenStore, src TokenSource) *AuthTransport {
 	return &AuthTransport{
-		Transport:      rt,
-		tokenStore:     ts,
-		clientID:       clientID,
-		clientSecret:   clientSecret,
+		Transport:   rt,
+		tokenStore:  ts,
+		tokenSource: src,
 	}
 }
 
 func (r *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set("Authorization", "Bearer " + r.tokenStore.GetAccessToken())
-
-	response, err := r.Transport.RoundTrip(req)
-	if err != nil {
+	expiredToken := r.tokenStore.GetAccessToken()
+	response, err := r.Transport.RoundTrip(authorize(req, expiredToken))
+	if err != nil || r.tokenSource == nil {
 		return response, err
 	}
-	return response, nil
+
+	if expired, err := TokenExpired(response); !expired || err != nil {
+		return response, nil
+	}
+
+	// the request can only be replayed if its body can be read again
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return response, nil
+		}
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return response, nil
+		}
+	}
+
+	response.Body.Close()
+
+	// another request may have replaced the expired token in the meantime
+	token := r.tokenStore.GetAccessToken()
+	if token == expiredToken {
+		token, err = r.tokenSource.FetchToken()
+		if err != nil {
+			return nil, &TokenRefreshError{Err: err}
+		}
+		r.tokenStore.SetAccessToken(token)
+	}
+
+	return r.Transport.RoundTrip(authorize(retry, token))
 }
 
+// authorize returns a copy of req with the bearer token set, RoundTrippers must not modify the request.
+func authorize(req *http.Request, token string) *http.Request {
+	authorized := req.Clone(req.Context())
+	authorized.Header.Set("Authorization", "Bearer "+token)
+	return authorized
+}
 
 func TokenExpired(resp *http.Response) (bool, error) {
 	if resp.StatusCode < 400 {
@@ -0,0 +1,117 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeTokenStore struct {
+	token string
+}
+
+func (s *fakeTokenStore) GetAccessToken() string  { return s.token }
+func (s *fakeTokenStore) SetAccessToken(t string) { s.token = t }
+
+type fakeTokenSource struct {
+	token string
+	err   error
+	calls int
+}
+
+func (s *fakeTokenSource) FetchToken() (string, error) {
+	s.calls++
+	return s.token, s.err
+}
+
+func TestAuthTransport_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      *fakeTokenSource
+		wantCode    int
+		wantBody    string
+		wantErr     bool
+		wantToken   string
+		wantFetches int
+	}{
+		{
+			name:        "valid_token",
+			source:      &fakeTokenSource{token: "new-token"},
+			wantCode:    200,
+			wantBody:    "payload",
+			wantToken:   "valid-token",
+			wantFetches: 0,
+		},
+		{
+			name:        "expired_token_replayed",
+			source:      &fakeTokenSource{token: "valid-token"},
+			wantCode:    200,
+			wantBody:    "payload",
+			wantToken:   "valid-token",
+			wantFetches: 1,
+		},
+		{
+			name:        "new_token_rejected",
+			source:      &fakeTokenSource{token: "other-token"},
+			wantCode:    401,
+			wantToken:   "other-token",
+			wantFetches: 1,
+		},
+		{
+			name:        "refresh_failed",
+			source:      &fakeTokenSource{err: errors.New("uaa is down")},
+			wantErr:     true,
+			wantToken:   "expired-token",
+			wantFetches: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer valid-token" {
+					w.WriteHeader(401)
+					fmt.Fprint(w, `{"error":"invalid_token"}`)
+					return
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				fmt.Fprint(w, string(body))
+			}))
+			defer svr.Close()
+
+			store := &fakeTokenStore{token: "expired-token"}
+			if tt.name == "valid_token" {
+				store.token = "valid-token"
+			}
+			client := &http.Client{Transport: NewAuthTransport(http.DefaultTransport, store, tt.source)}
+
+			req, _ := http.NewRequest("POST", svr.URL, strings.NewReader("payload"))
+			res, err := client.Do(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RoundTrip() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				defer res.Body.Close()
+				body, _ := ioutil.ReadAll(res.Body)
+				if res.StatusCode != tt.wantCode {
+					t.Errorf("RoundTrip() got code %d, want %d", res.StatusCode, tt.wantCode)
+				}
+				if tt.wantBody != "" && string(body) != tt.wantBody {
+					t.Errorf("RoundTrip() got body %q, want %q", body, tt.wantBody)
+				}
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Errorf("RoundTrip() modified the request headers")
+			}
+			if store.token != tt.wantToken {
+				t.Errorf("RoundTrip() stored token %q, want %q", store.token, tt.wantToken)
+			}
+			if tt.source.calls != tt.wantFetches {
+				t.Errorf("RoundTrip() fetched %d tokens, want %d", tt.source.calls, tt.wantFetches)
+			}
+		})
+	}
+}
@@ -1,50 +1,303 @@
 package net
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
-	"github.com/pkg/errors"
-
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	gonet "net"
 	"net/http"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
-// HTTPClient returns an http.Client that has TLS and proxy configured.
-func HTTPClient(insecure bool, cert []byte) (*http.Client, error) {
-	transport := Transport(true, nil)
-	if !insecure {
-		certPool, err := CertPool(cert)
-		if err != nil {
-			return nil, errors.Wrap(err, "net: could not create Cert Pool")
-		}
-		transport = Transport(false, certPool)
-	}
+var (
+	// ErrPinMismatch is returned when no certificate of a server matches the pinned public keys.
+	ErrPinMismatch = errors.New("net: no certificate of the server matches the pinned public keys")
+	// ErrChainNotVerified is reported to OnVerify when Insecure without CA
+	// certificates: there are no roots telling whether the chain would verify.
+	ErrChainNotVerified = errors.New("net: the chain isn't verified, certificates are insecurely trusted")
+)
+
+// TLSOptions tell how the certificates of a server are verified.
+type TLSOptions struct {
+	// Insecure skips the verification of the certificate chain and host name.
+	Insecure bool
+	// CACerts is a bundle of PEM encoded CA certificates.
+	CACerts []byte
+	// SystemRoots adds the CA certificates of the system to CACerts.
+	SystemRoots bool
+	// ServerName is sent with SNI and verified instead of the host of the URL, when set.
+	ServerName string
+	// MinVersion is the minimum TLS version, TLS 1.2 when zero.
+	MinVersion uint16
+	// PinnedSPKI are the base64 encoded SHA-256 hashes of the public keys the
+	// server may present. When set, a certificate of the chain must match one.
+	PinnedSPKI []string
+	// OnVerify, when set, is called on every handshake with the host:port of the
+	// server, the certificates it presented and why they didn't verify, so the
+	// certificates of a server failing the verification are reported as well.
+	// When Insecure, it's told whether the chain would verify, or ErrChainNotVerified
+	// without CA certificates to verify it against.
+	OnVerify func(addr string, certs []*x509.Certificate, err error)
+}
+
+// HTTPClient returns an http.Client sending its requests through transport.
+func HTTPClient(transport http.RoundTripper) *http.Client {
 	return &http.Client{
 		Transport: transport,
 		Timeout:   60 * time.Second,
-		//Jar:       nil,
-	}, nil
+	}
+}
+
+// Transport returns a new transport verifying the servers according to opts. It
+// is cloned from http.DefaultTransport, so it keeps its proxy and timeout settings.
+func Transport(opts TLSOptions) (*http.Transport, error) {
+	config, err := opts.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	if opts.OnVerify != nil {
+		transport.DialTLSContext = opts.dialTLS(config)
+	}
+	return transport, nil
+}
+
+// dialTLS returns a dial function whose connections verify the certificates of
+// the server in VerifyConnection rather than in the handshake, as the handshake
+// drops the certificates of a server failing the verification before OnVerify
+// could see them. The handshake itself is left to the http transport, which
+// traces it.
+func (opts TLSOptions) dialTLS(config *tls.Config) func(ctx context.Context, network, addr string) (gonet.Conn, error) {
+	dialer := &gonet.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	pins := opts.pins()
+	// the chain is verified when Insecure as well, to tell OnVerify whether it would
+	// verify, unless there are no roots to verify it against
+	roots, rootsErr := opts.RootCAs()
+	noRoots := opts.Insecure && len(opts.CACerts) == 0 && !opts.SystemRoots
+	return func(ctx context.Context, network, addr string) (gonet.Conn, error) {
+		host, _, err := gonet.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		connConfig := config.Clone()
+		if connConfig.ServerName == "" {
+			connConfig.ServerName = host
+		}
+		connConfig.InsecureSkipVerify = true
+		connConfig.VerifyPeerCertificate = nil
+		connConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			var chains [][]*x509.Certificate
+			chainErr := ErrChainNotVerified
+			if !noRoots {
+				chains, chainErr = verifyChain(state.PeerCertificates, roots, rootsErr, connConfig.ServerName)
+			}
+			err := chainErr
+			if opts.Insecure {
+				chains, err = nil, nil
+			}
+			if err == nil && len(pins) > 0 {
+				err = verifyPins(pins)(rawCerts(state.PeerCertificates), chains)
+			}
+
+			// report why the connection failed, else why the chain doesn't verify
+			if err != nil {
+				opts.OnVerify(addr, state.PeerCertificates, err)
+			} else {
+				opts.OnVerify(addr, state.PeerCertificates, chainErr)
+			}
+			return err
+		}
+		return tls.Client(conn, connConfig), nil
+	}
 }
 
-func Transport(insecure bool, certPool *x509.CertPool) *http.Transport {
-	transport := http.DefaultTransport.(*http.Transport)
-	transport.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: insecure,
+// verifyChain verifies the chain a server presented against roots for serverName.
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, rootsErr error, serverName string) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("net: the server presented no certificate")
+	}
+	if rootsErr != nil {
+		return nil, rootsErr
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
 	}
+	return certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+}
 
-	if !insecure {
-		transport.TLSClientConfig.RootCAs = certPool
+func rawCerts(certs []*x509.Certificate) [][]byte {
+	raw := make([][]byte, len(certs))
+	for i, cert := range certs {
+		raw[i] = cert.Raw
 	}
-	return transport
+	return raw
 }
 
-// CertPool returns an x509.CertPool for the provided cert.
-func CertPool(cert []byte) (*x509.CertPool, error) {
+// TLSConfig returns the tls.Config implementing opts.
+func (opts TLSOptions) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: opts.Insecure,
+		ServerName:         opts.ServerName,
+		MinVersion:         opts.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if !opts.Insecure {
+		roots, err := opts.RootCAs()
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = roots
+	}
+
+	if pins := opts.pins(); len(pins) > 0 {
+		config.VerifyPeerCertificate = verifyPins(pins)
+	}
+	return config, nil
+}
+
+func (opts TLSOptions) pins() map[string]bool {
+	pins := map[string]bool{}
+	for _, pin := range opts.PinnedSPKI {
+		pins[pin] = true
+	}
+	return pins
+}
+
+// RootCAs returns the pool of CA certificates the servers are verified against.
+func (opts TLSOptions) RootCAs() (*x509.CertPool, error) {
+	if !opts.SystemRoots {
+		return CertPool(opts.CACerts)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, errors.Wrap(err, "net: could not load the system cert pool")
+	}
+	if len(opts.CACerts) > 0 {
+		certs, err := ParseCerts(opts.CACerts)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			pool.AddCert(cert)
+		}
+	}
+	return pool, nil
+}
+
+// CertPool returns an x509.CertPool with every certificate of the PEM bundle.
+func CertPool(bundle []byte) (*x509.CertPool, error) {
+	certs, err := ParseCerts(bundle)
+	if err != nil {
+		return nil, err
+	}
 	certPool := x509.NewCertPool()
-	ok := certPool.AppendCertsFromPEM(cert)
-	if !ok {
-		return nil, errors.New("failed to load ca cert")
+	for _, cert := range certs {
+		certPool.AddCert(cert)
 	}
 	return certPool, nil
 }
 
+// ParseCerts parses the certificates of a PEM bundle. Unlike
+// x509.CertPool.AppendCertsFromPEM, it fails on a certificate it can't parse
+// rather than skipping it.
+func ParseCerts(bundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "net: could not parse certificate #%d of the ca cert", len(certs)+1)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("net: failed to load ca cert, no certificate found")
+	}
+	return certs, nil
+}
+
+// SPKIHash returns the pin of cert: the base64 encoded SHA-256 hash of its public key.
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ValidatePin checks that pin is a base64 encoded SHA-256 hash.
+func ValidatePin(pin string) error {
+	sum, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("%q isn't a base64 encoded sha256 hash", pin)
+	}
+	return nil
+}
+
+// verifyPins accepts a connection when a certificate of the chain has a pinned
+// public key. The verified chains are used when the chain was verified, else the
+// certificates the server presented.
+func verifyPins(pins map[string]bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		var certs []*x509.Certificate
+		for _, chain := range verifiedChains {
+			certs = append(certs, chain...)
+		}
+		if len(verifiedChains) == 0 {
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return errors.Wrap(err, "net: could not parse server certificate")
+				}
+				certs = append(certs, cert)
+			}
+		}
+
+		for _, cert := range certs {
+			if pins[SPKIHash(cert)] {
+				return nil
+			}
+		}
+		return ErrPinMismatch
+	}
+}
+
+// ParseTLSVersion parses a TLS version like "1.2".
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown tls version %q, versions are 1.0, 1.1, 1.2 and 1.3", version)
+}
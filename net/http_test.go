@@ -0,0 +1,127 @@
+package net
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer svr.Close()
+	tls12 := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tls12.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	tls12.StartTLS()
+	defer tls12.Close()
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw})
+	pin := SPKIHash(svr.Certificate())
+	otherPin := "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	tests := []struct {
+		name    string
+		url     string
+		opts    TLSOptions
+		wantErr bool
+		wantPin bool
+	}{
+		{"verified", svr.URL, TLSOptions{CACerts: caCert}, false, false},
+		{"unknown_ca", svr.URL, TLSOptions{SystemRoots: true}, true, false},
+		{"system_roots_and_ca", svr.URL, TLSOptions{CACerts: caCert, SystemRoots: true}, false, false},
+		{"server_name", svr.URL, TLSOptions{CACerts: caCert, ServerName: "example.com"}, false, false},
+		{"wrong_server_name", svr.URL, TLSOptions{CACerts: caCert, ServerName: "pks.example.org"}, true, false},
+		{"pinned", svr.URL, TLSOptions{CACerts: caCert, PinnedSPKI: []string{otherPin, pin}}, false, false},
+		{"pin_mismatch", svr.URL, TLSOptions{CACerts: caCert, PinnedSPKI: []string{otherPin}}, true, true},
+		{"insecure_pin_mismatch", svr.URL, TLSOptions{Insecure: true, PinnedSPKI: []string{otherPin}}, true, true},
+		{"insecure", svr.URL, TLSOptions{Insecure: true}, false, false},
+		{"insecure_with_ca", svr.URL, TLSOptions{Insecure: true, CACerts: caCert}, false, false},
+		{"min_version", tls12.URL, TLSOptions{CACerts: caCert, MinVersion: tls.VersionTLS13}, true, false},
+		{"default_min_version", tls12.URL, TLSOptions{CACerts: caCert}, false, false},
+	}
+	for _, tt := range tests {
+		for _, onVerify := range []bool{false, true} {
+			name := tt.name
+			if onVerify {
+				name += "_on_verify"
+			}
+			t.Run(name, func(t *testing.T) {
+				// verifying in the dial of OnVerify must behave like the handshake
+				var verified int
+				var verifyErr error
+				opts := tt.opts
+				if onVerify {
+					opts.OnVerify = func(addr string, certs []*x509.Certificate, err error) {
+						if len(certs) > 0 && certs[0].Equal(svr.Certificate()) {
+							verified++
+						}
+						verifyErr = err
+					}
+				}
+				transport, err := Transport(opts)
+				if err != nil {
+					t.Fatalf("Transport() error = %v", err)
+				}
+				defer transport.CloseIdleConnections()
+
+				res, err := HTTPClient(transport).Get(tt.url)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err == nil {
+					res.Body.Close()
+				}
+				if tt.wantPin && !errors.Is(err, ErrPinMismatch) {
+					t.Errorf("Get() error = %v, want %v", err, ErrPinMismatch)
+				}
+				// the certificates are reported when they don't verify as well, and
+				// whether they would verify when insecure, unless there's no CA to tell
+				if onVerify && tt.url == svr.URL {
+					notVerified := tt.opts.Insecure && tt.opts.CACerts == nil
+					wantVerifyErr := tt.wantErr || notVerified
+					if verified != 1 || (verifyErr != nil) != wantVerifyErr {
+						t.Errorf("OnVerify() called %d times with error %v, want once with error %v", verified, verifyErr, wantVerifyErr)
+					}
+					if notVerified && !tt.wantErr && verifyErr != ErrChainNotVerified {
+						t.Errorf("OnVerify() error = %v, want %v", verifyErr, ErrChainNotVerified)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestParseCerts(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	svr.Close()
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw})
+	key := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("key")})
+	invalid := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("not a certificate")})
+
+	tests := []struct {
+		name    string
+		bundle  []byte
+		want    int
+		wantErr bool
+	}{
+		{"single", cert, 1, false},
+		{"bundle", append(append([]byte{}, cert...), cert...), 2, false},
+		{"other_blocks", append(append([]byte{}, key...), cert...), 1, false},
+		{"invalid", append(append([]byte{}, cert...), invalid...), 0, true},
+		{"empty", nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCerts(tt.bundle)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCerts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseCerts() got %d certificates, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package net
+
+import (
+	"sync"
+)
+
+// SyncTokenStore is a TokenStore safe for concurrent use. Concurrent callers
+// refreshing the token share a single in-flight fetch, so an expired token
+// doesn't start a burst of token grants.
+//
+// The zero value is an empty store ready to use.
+type SyncTokenStore struct {
+	mu       sync.Mutex
+	token    string
+	inflight *tokenFetch
+}
+
+// tokenFetch is a fetch in flight. done is closed once token and err are set.
+type tokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// GetAccessToken returns the access token.
+func (s *SyncTokenStore) GetAccessToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token
+}
+
+// SetAccessToken sets the access token.
+func (s *SyncTokenStore) SetAccessToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Refresh calls fetch and stores the token it returns. If a refresh is already
+// in flight, Refresh waits for it and returns its result instead of calling fetch.
+func (s *SyncTokenStore) Refresh(fetch func() (string, error)) (string, error) {
+	s.mu.Lock()
+	if f := s.inflight; f != nil {
+		s.mu.Unlock()
+		<-f.done
+		return f.token, f.err
+	}
+	return s.fetch(fetch)
+}
+
+// Renew calls fetch and stores the token it returns, like Refresh, but doesn't
+// return the result of a refresh already in flight: it waits for it, then calls
+// fetch, so the token is fetched with the credentials fetch sees now. Callers of
+// Refresh meanwhile share the fetch of Renew.
+func (s *SyncTokenStore) Renew(fetch func() (string, error)) (string, error) {
+	s.mu.Lock()
+	for s.inflight != nil {
+		f := s.inflight
+		s.mu.Unlock()
+		<-f.done
+		s.mu.Lock()
+	}
+	return s.fetch(fetch)
+}
+
+// fetch calls fetch as the fetch in flight. s.mu must be held and no fetch in
+// flight; fetch unlocks s.mu while fetch runs.
+func (s *SyncTokenStore) fetch(fetch func() (string, error)) (string, error) {
+	f := &tokenFetch{done: make(chan struct{})}
+	s.inflight = f
+	s.mu.Unlock()
+
+	f.token, f.err = fetch()
+
+	s.mu.Lock()
+	if f.err == nil {
+		s.token = f.token
+	}
+	s.inflight = nil
+	s.mu.Unlock()
+	close(f.done)
+
+	return f.token, f.err
+}
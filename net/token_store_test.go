@@ -0,0 +1,166 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncTokenStore_Refresh(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:      "refreshed",
+			wantToken: "new-token",
+		},
+		{
+			name:      "refresh_failed",
+			err:       errors.New("uaa is down"),
+			wantToken: "old-token",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &SyncTokenStore{}
+			store.SetAccessToken("old-token")
+
+			var fetches int32
+			release := make(chan struct{})
+			fetch := func() (string, error) {
+				atomic.AddInt32(&fetches, 1)
+				<-release
+				return "new-token", tt.err
+			}
+
+			const callers = 20
+			var wg sync.WaitGroup
+			errs := make(chan error, callers)
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := store.Refresh(fetch)
+					errs <- err
+				}()
+			}
+			// let the callers pile up on the first fetch
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Refresh() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+			if got := atomic.LoadInt32(&fetches); got != 1 {
+				t.Errorf("fetches = %d, want 1", got)
+			}
+			if got := store.GetAccessToken(); got != tt.wantToken {
+				t.Errorf("GetAccessToken() = %q, want %q", got, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestSyncTokenStore_Renew(t *testing.T) {
+	store := &SyncTokenStore{}
+	store.SetAccessToken("old-token")
+
+	release := make(chan struct{})
+	refreshed := make(chan string)
+	go func() {
+		token, _ := store.Refresh(func() (string, error) {
+			<-release
+			return "stale-token", nil
+		})
+		refreshed <- token
+	}()
+	// let the refresh start before renewing
+	time.Sleep(20 * time.Millisecond)
+
+	renewed := make(chan string)
+	go func() {
+		token, err := store.Renew(func() (string, error) { return "new-token", nil })
+		if err != nil {
+			t.Errorf("Renew() error = %v", err)
+		}
+		renewed <- token
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if got := <-refreshed; got != "stale-token" {
+		t.Errorf("Refresh() = %q, want stale-token", got)
+	}
+	if got := <-renewed; got != "new-token" {
+		t.Errorf("Renew() = %q, want new-token rather than the token of the refresh in flight", got)
+	}
+	if got := store.GetAccessToken(); got != "new-token" {
+		t.Errorf("GetAccessToken() = %q, want new-token", got)
+	}
+}
+
+// storeSource fetches tokens through a SyncTokenStore, as the monitor does.
+type storeSource struct {
+	store   *SyncTokenStore
+	fetches int32
+}
+
+func (s *storeSource) FetchToken() (string, error) {
+	return s.store.Refresh(func() (string, error) {
+		atomic.AddInt32(&s.fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "valid-token", nil
+	})
+}
+
+func TestAuthTransport_RoundTrip_Concurrent(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer valid-token" {
+			w.WriteHeader(401)
+			fmt.Fprint(w, `{"error":"invalid_token"}`)
+			return
+		}
+		fmt.Fprint(w, "payload")
+	}))
+	defer svr.Close()
+
+	store := &SyncTokenStore{}
+	store.SetAccessToken("expired-token")
+	src := &storeSource{store: store}
+	client := &http.Client{Transport: NewAuthTransport(http.DefaultTransport, store, src)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Get(svr.URL)
+			if err != nil {
+				t.Errorf("Get() error = %v", err)
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode != 200 {
+				t.Errorf("StatusCode = %d, want 200", res.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&src.fetches); got != 1 {
+		t.Errorf("fetches = %d, want 1", got)
+	}
+}
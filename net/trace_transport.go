@@ -0,0 +1,126 @@
+package net
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings are the durations of the phases of an HTTP request. Phases that
+// didn't happen, like the DNS lookup on a reused connection, are zero.
+type Timings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is the time from the start of the request to the first response byte.
+	TTFB time.Duration
+	// Total is the time from the start of the request until its body was closed.
+	Total time.Duration
+}
+
+// TraceTransport times the phases of every request with net/http/httptrace.
+type TraceTransport struct {
+	Transport http.RoundTripper
+	// OnTimings is called once the response body is closed, or when the request failed.
+	OnTimings func(Timings)
+}
+
+func NewTraceTransport(rt http.RoundTripper, onTimings func(Timings)) *TraceTransport {
+	return &TraceTransport{
+		Transport: rt,
+		OnTimings: onTimings,
+	}
+}
+
+func (t *TraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := &tracer{start: time.Now()}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tr.clientTrace()))
+
+	response, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		t.OnTimings(tr.done())
+		return response, err
+	}
+
+	response.Body = &tracedBody{
+		ReadCloser: response.Body,
+		onClose: func() {
+			t.OnTimings(tr.done())
+		},
+	}
+	return response, nil
+}
+
+// tracer collects the timings of a single request. The httptrace hooks may be
+// called from the dialing goroutines, so every access is locked.
+type tracer struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	timings      Timings
+}
+
+func (tr *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.timings.DNS = time.Since(tr.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.timings.Connect = time.Since(tr.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.timings.TLS = time.Since(tr.tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.timings.TTFB = time.Since(tr.start)
+		},
+	}
+}
+
+func (tr *tracer) done() Timings {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.timings.Total = time.Since(tr.start)
+	return tr.timings
+}
+
+// tracedBody calls onClose the first time the body is closed.
+type tracedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	onClose func()
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.onClose)
+	return err
+}
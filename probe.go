@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeTimeoutOffset is kept from the scrape timeout Prometheus sends, so the
+// probe answers before Prometheus gives up on it.
+const probeTimeoutOffset = 500 * time.Millisecond
+
+// ProbeHandler serves the multi-target pattern of the blackbox exporter: it runs
+// the checks of the target given with ?target= synchronously and serves the
+// metrics of that probe only. The target is the name of a configured target,
+// whose credentials are used. ?module= names the check to run, all the checks
+// of the target run without it. A probe is bounded by timeout, and by the scrape
+// timeout of Prometheus when it's shorter.
+func ProbeHandler(monitors []*PksMonitor, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		target := params.Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		pks := findMonitor(monitors, target)
+		if pks == nil {
+			http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusBadRequest)
+			return
+		}
+		module := params.Get("module")
+		if module != "" && !pks.Registry().Has(module) {
+			http.Error(w, fmt.Sprintf("unknown module %q of target %q", module, target), http.StatusBadRequest)
+			return
+		}
+
+		probeTimeout := timeout
+		if scrapeTimeout := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); scrapeTimeout != "" {
+			if secs, err := strconv.ParseFloat(scrapeTimeout, 64); err == nil {
+				if d := time.Duration(secs*float64(time.Second)) - probeTimeoutOffset; d > 0 && d < probeTimeout {
+					probeTimeout = d
+				}
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(probe(ctx, pks, module)...)
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// probe runs the check named module of pks, or all its checks when module is
+// empty, and returns the metrics of the probe. The checks run in isolation, the
+// metrics, status and history of the foundation are left as they are.
+func probe(ctx context.Context, pks *PksMonitor, module string) []prometheus.Collector {
+	probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_success",
+		Help: "Did every check of the probe succeed?",
+	})
+	probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "probe_duration_seconds",
+		Help: "How long the probe took to complete in seconds.",
+	})
+	checkSuccess := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "probe_check_success",
+		Help: "Did the check succeed?",
+	}, []string{"check", "reason"})
+
+	start := time.Now()
+	results := pks.Registry().Probe(ctx, module)
+	probeDuration.Set(time.Since(start).Seconds())
+
+	success := len(results) > 0
+	for _, res := range results {
+		success = success && res.Up
+		checkSuccess.WithLabelValues(res.Check, res.Reason).Set(boolToFloat(res.Up))
+	}
+	probeSuccess.Set(boolToFloat(success))
+
+	return []prometheus.Collector{probeSuccess, probeDuration, checkSuccess}
+}
+
+func findMonitor(monitors []*PksMonitor, foundation string) *PksMonitor {
+	for _, m := range monitors {
+		if m.Foundation() == foundation {
+			return m
+		}
+	}
+	return nil
+}
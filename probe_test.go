@@ -0,0 +1,156 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeHandler(t *testing.T) {
+	pks := newTestRegistryMonitor(t, "test",
+		&fakeCheck{name: "pks_api", up: true},
+		&fakeCheck{name: "uaa", err: errors.New("uaa is down")},
+	)
+	handler := ProbeHandler([]*PksMonitor{pks}, time.Second)
+
+	tests := []struct {
+		name     string
+		url      string
+		wantCode int
+		want     []string
+	}{
+		{
+			name:     "module_up",
+			url:      "/probe?target=test&module=pks_api",
+			wantCode: http.StatusOK,
+			want:     []string{"probe_success 1", `probe_check_success{check="pks_api",reason=""} 1`, "probe_duration_seconds "},
+		},
+		{
+			name:     "module_down",
+			url:      "/probe?target=test&module=uaa",
+			wantCode: http.StatusOK,
+			want:     []string{"probe_success 0", `probe_check_success{check="uaa",reason="unknown"} 0`},
+		},
+		{
+			name:     "all_modules",
+			url:      "/probe?target=test",
+			wantCode: http.StatusOK,
+			want:     []string{"probe_success 0", `probe_check_success{check="pks_api",reason=""} 1`, `probe_check_success{check="uaa",reason="unknown"} 0`},
+		},
+		{"missing_target", "/probe", http.StatusBadRequest, nil},
+		{"unknown_target", "/probe?target=prod", http.StatusBadRequest, nil},
+		{"unknown_module", "/probe?target=test&module=bosh", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("GET %s got %d, want %d", tt.url, rec.Code, tt.wantCode)
+			}
+			body := rec.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("GET %s body doesn't contain %q:\n%s", tt.url, want, body)
+				}
+			}
+			// the probe serves its own metrics only
+			if strings.Contains(body, "wf_opp_") {
+				t.Errorf("GET %s serves the metrics of the monitor:\n%s", tt.url, body)
+			}
+		})
+	}
+}
+
+func TestProbeHandler_Isolated(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  bool
+		// status and body answer the probe
+		status     int
+		body       string
+		wantReason string
+	}{
+		{"api_down", false, http.StatusServiceUnavailable, "", ReasonHTTP5xx},
+		{"token_rejected_over_tls", true, http.StatusUnauthorized, `{"error":"invalid_token"}`, ReasonTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var probing int32
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&probing) == 1 || r.URL.Path != pksListClusters {
+					w.WriteHeader(tt.status)
+					fmt.Fprint(w, tt.body)
+					return
+				}
+				fmt.Fprintln(w, clustersResp)
+			})
+			svr := httptest.NewServer(handler)
+			if tt.tls {
+				svr.Close()
+				svr = httptest.NewTLSServer(handler)
+			}
+			defer svr.Close()
+
+			pks := newTestMonitor(t, svr.URL, svr.URL, "fakeToken")
+			if res, ok := pks.Registry().RunCheck(context.Background(), "pks_api"); !ok || !res.Up {
+				t.Fatalf("RunCheck() got = %+v, want pks_api up", res)
+			}
+			// the certificates recorded by the probe would show up again
+			pks.metrics.tlsCertExpiry.Reset()
+			pks.metrics.tlsCertVerified.Reset()
+
+			// the probe of every check fails, the state of the foundation is still the one of the last check
+			atomic.StoreInt32(&probing, 1)
+			rec := httptest.NewRecorder()
+			ProbeHandler([]*PksMonitor{pks}, time.Second).ServeHTTP(rec, httptest.NewRequest("GET", "/probe?target=test", nil))
+			want := fmt.Sprintf(`probe_check_success{check="pks_api",reason=%q} 0`, tt.wantReason)
+			if body := rec.Body.String(); !strings.Contains(body, want) || !strings.Contains(body, `probe_check_success{check="uaa"`) {
+				t.Fatalf("GET /probe body doesn't report pks_api and uaa down:\n%s", body)
+			}
+
+			gauges := []struct {
+				metric string
+				labels map[string]string
+			}{
+				{"wf_opp_pks_api_up", nil},
+				{"wf_opp_check_up", map[string]string{"check": "pks_api"}},
+				{"wf_opp_pks_cluster_info", map[string]string{"name": "cluster-1"}},
+			}
+			for _, g := range gauges {
+				if got, ok := gaugeValue(t, pks, g.metric, g.labels); !ok || got != 1 {
+					t.Errorf("probe changed %s%v to %v, want 1", g.metric, g.labels, got)
+				}
+			}
+			unchanged := []string{"wf_opp_pks_api_check_failures_total", "wf_opp_uaa_token_grant_errors_total", "wf_opp_tls_cert_expiry_seconds", "wf_opp_tls_cert_chain_verified"}
+			for _, metric := range unchanged {
+				if m, ok := findMetric(t, pks, metric, nil); ok {
+					t.Errorf("probe recorded %s: %v", metric, m)
+				}
+			}
+			for _, metric := range []string{"wf_opp_token_expiry_timestamp_seconds", "wf_opp_uaa_up"} {
+				if got, _ := gaugeValue(t, pks, metric, nil); got != 0 {
+					t.Errorf("probe set %s to %v, want it unset", metric, got)
+				}
+			}
+			if got, _ := counterValue(t, pks, "wf_opp_token_refresh_errors_total", nil); got != 0 {
+				t.Errorf("probe counted %v token refresh errors, want none", got)
+			}
+			if got := pks.config.GetAccessToken(); got != "fakeToken" {
+				t.Errorf("probe refreshed the access token to %q", got)
+			}
+			if got := pks.Registry().History("pks_api"); len(got) != 1 || !got[0].Up {
+				t.Errorf("probe changed the history to %+v, want the last check only", got)
+			}
+			if got := pks.Clusters(); len(got) != 1 {
+				t.Errorf("probe changed the clusters to %+v, want cluster-1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultReloadInterval is how often the credential files are checked for changes.
+const DefaultReloadInterval = 30 * time.Second
+
+// credentialFiles are the contents of the credential files of a target, as last read.
+type credentialFiles struct {
+	secret string
+	caCert string
+}
+
+// readCredentialFiles reads the CA certificates and the UAA client secret of target.
+// The secret is taken from UaaCliSecret when the target has no UaaCliSecretFile.
+// The default CA certificates file may be missing when the target doesn't need it.
+func readCredentialFiles(target Target) (credentialFiles, error) {
+	caCertFile := target.CACertFile
+	if caCertFile == "" {
+		caCertFile = DefaultCACertFile
+	}
+	caCert, err := ioutil.ReadFile(caCertFile)
+	if os.IsNotExist(err) && target.CACertFile == "" && (target.TLS.SystemRoots || target.TLS.InsecureSkipVerify) {
+		caCert, err = nil, nil
+	}
+	if err != nil {
+		return credentialFiles{}, errors.Wrap(err, "pks-monitor: couldn't read certs")
+	}
+
+	files := credentialFiles{secret: target.UaaCliSecret, caCert: string(caCert)}
+	if target.UaaCliSecretFile != "" {
+		secret, err := ioutil.ReadFile(target.UaaCliSecretFile)
+		if err != nil {
+			return credentialFiles{}, errors.Wrap(err, "pks-monitor: couldn't read uaa client secret")
+		}
+		files.secret = strings.TrimSpace(string(secret))
+	}
+	return files, nil
+}
+
+// Reload reads the credential files of the target again. When they changed, the
+// http client is rebuilt with the new CA certificates and the monitor logs in
+// with the new secret, keeping the current token until the new one is verified.
+// Invalid CA certificates aren't loaded, the monitor keeps using the current
+// credentials until they're fixed. Reload returns false when nothing changed.
+func (pks *PksMonitor) Reload() (bool, error) {
+	files, err := readCredentialFiles(pks.target)
+	if err != nil {
+		pks.metrics.configReloadSuccess.Set(0)
+		return false, err
+	}
+
+	pks.mu.Lock()
+	if files == pks.files {
+		pks.mu.Unlock()
+		return false, nil
+	}
+	// the current credentials are kept when the new CA certificates are invalid
+	if err := pks.config.SetCredentials(files.secret, files.caCert); err != nil {
+		pks.mu.Unlock()
+		pks.metrics.configReloadSuccess.Set(0)
+		return true, err
+	}
+	client, err := pks.newClient()
+	if err != nil {
+		pks.mu.Unlock()
+		pks.metrics.configReloadSuccess.Set(0)
+		return true, err
+	}
+	pks.client = client
+	pks.files = files
+	pks.mu.Unlock()
+
+	if err := pks.tokens.Renew(pks.verifyToken); err != nil {
+		pks.metrics.configReloadSuccess.Set(0)
+		return true, errors.Wrap(err, "pks-monitor: couldn't login with the reloaded credentials")
+	}
+	pks.reloaded()
+	return true, nil
+}
+
+// reloaded records a successful load of the credentials.
+func (pks *PksMonitor) reloaded() {
+	pks.metrics.configReloadSuccess.Set(1)
+	pks.metrics.configLastReloadSuccess.SetToCurrentTime()
+}
+
+// WatchCredentials reloads the credential files every interval until ctx is done.
+// Files mounted from a Kubernetes secret are replaced through a symlink, so their
+// content is compared rather than watching for file events.
+func (pks *PksMonitor) WatchCredentials(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		reloaded, err := pks.Reload()
+		switch {
+		case err != nil:
+			fmt.Printf("pks-monitor: %s: couldn't reload credentials: %v\n", pks.Foundation(), err)
+		case reloaded:
+			fmt.Printf("pks-monitor: %s: credentials reloaded\n", pks.Foundation())
+		}
+	}
+}
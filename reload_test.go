@@ -0,0 +1,267 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPksMonitor_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pks-monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	caCertFile := filepath.Join(dir, "cert.pem")
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(secretFile, "old-secret\n")
+	write(caCertFile, "old-ca")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken := signedToken(t, key, []string{"pks.clusters.admin"})
+	unscopedToken := signedToken(t, key, []string{"uaa.none"})
+
+	authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token_keys":
+			writeTokenKeys(w, key)
+			return
+		case "/oauth/token":
+		default:
+			return
+		}
+		r.ParseForm()
+		switch r.PostForm.Get("client_secret") {
+		case "new-secret":
+			fmt.Fprintf(w, `{"access_token":%q}`, newToken)
+		case "unscoped-secret":
+			fmt.Fprintf(w, `{"access_token":%q}`, unscopedToken)
+		default:
+			w.WriteHeader(401)
+			fmt.Fprintln(w, `{"error":"unauthorized"}`)
+		}
+	}))
+	defer authSvr.Close()
+
+	pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "old-token")
+	pks.target.UaaCliSecretFile = secretFile
+	pks.target.CACertFile = caCertFile
+	pks.files, err = readCredentialFiles(pks.target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := pks.httpClient()
+
+	tests := []struct {
+		name         string
+		secret       string
+		caCert       string
+		wantReloaded bool
+		wantErr      bool
+		wantSuccess  float64
+		wantToken    string
+	}{
+		{"unchanged", "old-secret\n", "old-ca", false, false, 0, "old-token"},
+		{"rejected_secret", "bad-secret", "old-ca", true, true, 0, "old-token"},
+		{"unverified_token", "unscoped-secret", "old-ca", true, true, 0, "old-token"},
+		{"rotated", "new-secret", "new-ca", true, false, 1, newToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(secretFile, tt.secret)
+			write(caCertFile, tt.caCert)
+
+			reloaded, err := pks.Reload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reloaded != tt.wantReloaded {
+				t.Errorf("Reload() = %v, want %v", reloaded, tt.wantReloaded)
+			}
+			if got := pks.config.GetAccessToken(); got != tt.wantToken {
+				t.Errorf("access token = %v, want %v", got, tt.wantToken)
+			}
+			if got, _ := gaugeValue(t, pks, "wf_opp_config_reload_success", nil); got != tt.wantSuccess {
+				t.Errorf("wf_opp_config_reload_success = %v, want %v", got, tt.wantSuccess)
+			}
+		})
+	}
+
+	if pks.config.GetCACert() != "new-ca" {
+		t.Errorf("CACert = %q, want new-ca", pks.config.GetCACert())
+	}
+	if pks.httpClient() == client {
+		t.Errorf("Reload() didn't rebuild the http client")
+	}
+	if got, _ := gaugeValue(t, pks, "wf_opp_config_last_reload_success_timestamp_seconds", nil); got == 0 {
+		t.Errorf("wf_opp_config_last_reload_success_timestamp_seconds = 0, want the time of the reload")
+	}
+
+	os.Remove(secretFile)
+	if _, err := pks.Reload(); err == nil {
+		t.Errorf("Reload() of a missing file error = nil, want an error")
+	}
+}
+
+func TestPksMonitor_Reload_RefreshInFlight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pks-monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretFile := filepath.Join(dir, "secret")
+	caCertFile := filepath.Join(dir, "cert.pem")
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(secretFile, "old-secret")
+	write(caCertFile, "ca")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken := signedToken(t, key, []string{"pks.clusters.admin"})
+	newToken := signedToken(t, key, []string{"pks.clusters.manage"})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var newGrants int32
+	authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token_keys":
+			writeTokenKeys(w, key)
+		case "/oauth/token":
+			r.ParseForm()
+			if r.PostForm.Get("client_secret") == "new-secret" {
+				atomic.AddInt32(&newGrants, 1)
+				fmt.Fprintf(w, `{"access_token":%q}`, newToken)
+				return
+			}
+			// the grant with the old secret is in flight until released
+			close(started)
+			<-release
+			fmt.Fprintf(w, `{"access_token":%q}`, oldToken)
+		}
+	}))
+	defer authSvr.Close()
+
+	pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "")
+	pks.target.UaaCliSecretFile = secretFile
+	pks.target.CACertFile = caCertFile
+	if pks.files, err = readCredentialFiles(pks.target); err != nil {
+		t.Fatal(err)
+	}
+	if err := pks.config.SetCredentials(pks.files.secret, pks.files.caCert); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := make(chan error)
+	go func() { refreshed <- pks.Tokens().Refresh() }()
+	<-started
+
+	write(secretFile, "new-secret")
+	type result struct {
+		reloaded bool
+		err      error
+	}
+	reloaded := make(chan result)
+	go func() {
+		ok, err := pks.Reload()
+		reloaded <- result{ok, err}
+	}()
+	// let the reload wait on the refresh in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := <-refreshed; err != nil {
+		t.Errorf("Refresh() error = %v", err)
+	}
+	if got := <-reloaded; !got.reloaded || got.err != nil {
+		t.Fatalf("Reload() = %v, %v, want true, nil", got.reloaded, got.err)
+	}
+	if got := atomic.LoadInt32(&newGrants); got != 1 {
+		t.Errorf("grants with the new secret = %d, want 1", got)
+	}
+	if got := pks.config.GetAccessToken(); got != newToken {
+		t.Errorf("access token = %v, want the token granted with the new secret", got)
+	}
+	if got, _ := gaugeValue(t, pks, "wf_opp_config_reload_success", nil); got != 1 {
+		t.Errorf("wf_opp_config_reload_success = %v, want 1", got)
+	}
+}
+
+func TestPksMonitor_Reload_InvalidCACert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pks-monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var grants int
+	authSvr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			grants++
+			fmt.Fprintf(w, `{"access_token":"token-%d"}`, grants)
+		}
+	}))
+	defer authSvr.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: authSvr.Certificate().Raw}))
+	caCertFile := filepath.Join(dir, "cert.pem")
+	if err := ioutil.WriteFile(caCertFile, []byte(caCert), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "")
+	pks.config.SkipSSLVerification = false
+	pks.config.CACert = caCert
+	pks.config.apiTransport, pks.config.uaaTransport = nil, nil
+	pks.target.UaaCliSecret = "fakeSecret"
+	pks.target.CACertFile = caCertFile
+	if pks.files, err = readCredentialFiles(pks.target); err != nil {
+		t.Fatal(err)
+	}
+	if err := pks.Tokens().Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	broken := "-----BEGIN CERTIFICATE-----\nYnJva2Vu\n-----END CERTIFICATE-----\n"
+	if err := ioutil.WriteFile(caCertFile, []byte(broken), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pks.Reload(); err == nil {
+		t.Fatalf("Reload() of a broken ca cert error = nil, want an error")
+	}
+	if got := pks.config.GetCACert(); got != caCert {
+		t.Errorf("CACert = %q, want the previous ca cert", got)
+	}
+	if got, _ := gaugeValue(t, pks, "wf_opp_config_reload_success", nil); got != 0 {
+		t.Errorf("wf_opp_config_reload_success = %v, want 0", got)
+	}
+
+	// the token is still refreshed with the previous ca cert
+	if err := pks.Tokens().Refresh(); err != nil {
+		t.Fatalf("Refresh() after a broken ca cert error = %v", err)
+	}
+	if got := pks.config.GetAccessToken(); got != "token-2" {
+		t.Errorf("access token = %v, want token-2", got)
+	}
+}
@@ -0,0 +1,64 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Modes telling when the checks run.
+const (
+	// ModeInterval runs the checks every check interval, scrapes serve the last results.
+	ModeInterval = "interval"
+	// ModeScrape runs the checks when the metrics are scraped.
+	ModeScrape = "scrape"
+)
+
+// DefaultScrapeMinAge is how long results are served before a scrape runs the checks again.
+const DefaultScrapeMinAge = 10 * time.Second
+
+// scrapeRunner runs the checks of a foundation when it's scraped. Concurrent
+// scrapes wait for the same run, and results younger than minAge are served
+// without running the checks again.
+type scrapeRunner struct {
+	minAge  time.Duration
+	timeout time.Duration
+	run     func(ctx context.Context)
+
+	mu       sync.Mutex
+	last     time.Time
+	inflight chan struct{}
+}
+
+// scrape runs the checks, or waits for the run in flight, unless the last run
+// is younger than minAge. The run is bounded by timeout, when positive.
+func (s *scrapeRunner) scrape() {
+	s.mu.Lock()
+	if !s.last.IsZero() && time.Since(s.last) < s.minAge {
+		s.mu.Unlock()
+		return
+	}
+	if s.inflight != nil {
+		done := s.inflight
+		s.mu.Unlock()
+		<-done
+		return
+	}
+	done := make(chan struct{})
+	s.inflight = done
+	s.mu.Unlock()
+
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	s.run(ctx)
+
+	s.mu.Lock()
+	s.last = time.Now()
+	s.inflight = nil
+	s.mu.Unlock()
+	close(done)
+}
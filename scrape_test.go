@@ -0,0 +1,75 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScrapeRunner(t *testing.T) {
+	var runs int32
+	release := make(chan struct{})
+	s := &scrapeRunner{
+		minAge:  time.Hour,
+		timeout: time.Second,
+		run: func(ctx context.Context) {
+			atomic.AddInt32(&runs, 1)
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("scrape() run without deadline")
+			}
+			<-release
+		},
+	}
+
+	// concurrent scrapes wait for the same run
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.scrape()
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&runs); got != 1 {
+		t.Errorf("scrape() concurrently got %d runs, want 1", got)
+	}
+
+	// results younger than minAge are served from the last run
+	s.scrape()
+	if got := atomic.LoadInt32(&runs); got != 1 {
+		t.Errorf("scrape() within minAge got %d runs, want 1", got)
+	}
+
+	s.minAge = 0
+	s.scrape()
+	if got := atomic.LoadInt32(&runs); got != 2 {
+		t.Errorf("scrape() past minAge got %d runs, want 2", got)
+	}
+}
+
+func TestPksMonitor_Collect_ScrapeMode(t *testing.T) {
+	pks := newTestRegistryMonitor(t, "test", &fakeCheck{name: "scraped", up: true})
+	pks.scraper = &scrapeRunner{timeout: time.Second, run: func(ctx context.Context) { pks.Run(ctx) }}
+
+	before := float64(time.Now().Unix())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(pks)
+	if _, err := reg.Gather(); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[string]string{"foundation": "test", "check": "scraped"}
+	if up, ok := gaugeValue(t, pks.metrics, "wf_opp_check_up", labels); !ok || up != 1 {
+		t.Errorf("wf_opp_check_up = %v (found %t), want 1", up, ok)
+	}
+	if ts, ok := gaugeValue(t, pks.metrics, "wf_opp_last_check_timestamp_seconds", labels); !ok || ts < before {
+		t.Errorf("wf_opp_last_check_timestamp_seconds = %v (found %t), want at least %v", ts, ok, before)
+	}
+}
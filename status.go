@@ -0,0 +1,96 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CheckStatus is the JSON representation of a check Result.
+type CheckStatus struct {
+	Foundation string    `json:"foundation"`
+	Check      string    `json:"check"`
+	Up         bool      `json:"up"`
+	Timestamp  time.Time `json:"timestamp"`
+	Duration   float64   `json:"duration_seconds"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func NewCheckStatus(res Result) CheckStatus {
+	status := CheckStatus{
+		Foundation: res.Foundation,
+		Check:      res.Check,
+		Up:         res.Up,
+		Timestamp:  res.Timestamp,
+		Duration:   res.Duration.Seconds(),
+		StatusCode: res.StatusCode,
+		Reason:     res.Reason,
+	}
+	if res.Err != nil {
+		status.Error = res.Err.Error()
+	}
+	return status
+}
+
+// ClusterStatus is a cluster of a foundation, as last listed by the Pks Api.
+type ClusterStatus struct {
+	Foundation string `json:"foundation"`
+	Cluster
+}
+
+// StatusHandler serves the last result of every check and the clusters of every
+// foundation, or of the foundation given with ?foundation=.
+func StatusHandler(monitors []*PksMonitor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		foundation := r.URL.Query().Get("foundation")
+		checks := []CheckStatus{}
+		clusters := []ClusterStatus{}
+		for _, m := range monitors {
+			if foundation != "" && m.Foundation() != foundation {
+				continue
+			}
+			for _, res := range m.Registry().Last() {
+				checks = append(checks, NewCheckStatus(res))
+			}
+			for _, c := range m.Clusters() {
+				clusters = append(clusters, ClusterStatus{Foundation: m.Foundation(), Cluster: c})
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"checks": checks, "clusters": clusters})
+	})
+}
+
+// HistoryHandler serves the last results of the check named by the {name} route
+// variable, newest first, for every foundation or the one given with ?foundation=.
+func HistoryHandler(monitors []*PksMonitor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if !contains(Checks, name) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown check %q", name)})
+			return
+		}
+
+		foundation := r.URL.Query().Get("foundation")
+		results := []CheckStatus{}
+		for _, m := range monitors {
+			if foundation != "" && m.Foundation() != foundation {
+				continue
+			}
+			for _, res := range m.Registry().History(name) {
+				results = append(results, NewCheckStatus(res))
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"check": name, "results": results})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
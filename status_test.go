@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	var monitors []*PksMonitor
+	for _, name := range []string{"sandbox", "prod"} {
+		pks := newTestRegistryMonitor(t, name,
+			&fakeCheck{name: "pks_api", err: &Failure{Reason: ReasonHTTP5xx, StatusCode: 503, Err: errors.New("response status code: 503")}},
+			&fakeCheck{name: "uaa", up: true},
+		)
+		pks.Run(context.Background())
+		pks.Run(context.Background())
+		monitors = append(monitors, pks)
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/api/v1/checks/{name}/history", HistoryHandler(monitors))
+
+	tests := []struct {
+		name        string
+		url         string
+		wantCode    int
+		wantResults int
+	}{
+		{"all_foundations", "/api/v1/checks/pks_api/history", http.StatusOK, 4},
+		{"one_foundation", "/api/v1/checks/pks_api/history?foundation=prod", http.StatusOK, 2},
+		{"unknown_check", "/api/v1/checks/bosh/history", http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("GET %s got %d, want %d", tt.url, rec.Code, tt.wantCode)
+			}
+
+			var body struct {
+				Results []CheckStatus `json:"results"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Results) != tt.wantResults {
+				t.Fatalf("GET %s got %d results, want %d", tt.url, len(body.Results), tt.wantResults)
+			}
+			for _, res := range body.Results {
+				if res.Up || res.Check != "pks_api" || res.Reason != ReasonHTTP5xx || res.Error == "" {
+					t.Errorf("GET %s got %+v, want a failed pks_api result", tt.url, res)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	pks := newTestMonitor(t, "https://127.0.0.1:9021", "https://127.0.0.1:8443", "")
+	pks.setClusters([]Cluster{{Name: "cluster-1", PlanName: "small"}})
+
+	rec := httptest.NewRecorder()
+	StatusHandler([]*PksMonitor{pks}).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/status", nil))
+
+	var body struct {
+		Clusters []ClusterStatus `json:"clusters"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Clusters) != 1 || body.Clusters[0].Foundation != "test" || body.Clusters[0].Name != "cluster-1" {
+		t.Errorf("StatusHandler() clusters = %+v, want cluster-1 of test", body.Clusters)
+	}
+}
@@ -0,0 +1,213 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	pksNet "github.com/pupimvictor/pks-monitor/net"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultCACertFile is where the PKS TLS certificate is mounted when a target doesn't set one.
+const DefaultCACertFile = "/etc/pks-monitor/certs/cert.pem"
+
+var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// Target is a PKS foundation to monitor. API and UAA are URLs, the default PKS
+// ports are used when they have none. Without UAA, the UAA URL is discovered
+// from the UAA server on port 8443 of the API host. The UAA client secret is set inline,
+// or read from the environment variable UaaCliSecretEnv or from UaaCliSecretFile,
+// which is reloaded like CACertFile when it changes. TLS tunes how the
+// certificates of the target are verified.
+type Target struct {
+	Name             string            `yaml:"name"`
+	API              string            `yaml:"api"`
+	UAA              string            `yaml:"uaa"`
+	UaaCliId         string            `yaml:"uaa_cli_id"`
+	UaaCliSecret     string            `yaml:"uaa_cli_secret"`
+	UaaCliSecretEnv  string            `yaml:"uaa_cli_secret_env"`
+	UaaCliSecretFile string            `yaml:"uaa_cli_secret_file"`
+	CACertFile       string            `yaml:"ca_cert_file"`
+	TLS              TargetTLS         `yaml:"tls"`
+	Labels           map[string]string `yaml:"labels"`
+}
+
+// TargetTLS tunes how the certificates of the PKS API and UAA of a target are
+// verified. They are verified against the CA certificates of CACertFile, which may
+// be a bundle of several PEM certificates, and the system's when SystemRoots is set.
+// ServerName is the name the certificates are verified for and is sent with SNI,
+// for servers reached through an IP or a name their certificate doesn't have.
+// PinnedSPKI are base64 encoded SHA-256 hashes of public keys, one of which a
+// certificate of the chain must have. ServerName and PinnedSPKI apply to the PKS
+// API, UAAServerName and UAAPinnedSPKI to UAA, which may be another host.
+type TargetTLS struct {
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify"`
+	SystemRoots        bool     `yaml:"system_roots"`
+	ServerName         string   `yaml:"server_name"`
+	MinVersion         string   `yaml:"min_version"`
+	PinnedSPKI         []string `yaml:"pinned_spki_sha256"`
+	UAAServerName      string   `yaml:"uaa_server_name"`
+	UAAPinnedSPKI      []string `yaml:"uaa_pinned_spki_sha256"`
+}
+
+// minVersion returns the minimum TLS version, 0 for the default one.
+func (t TargetTLS) minVersion() (uint16, error) {
+	if t.MinVersion == "" {
+		return 0, nil
+	}
+	return pksNet.ParseTLSVersion(t.MinVersion)
+}
+
+type targetsFile struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// LoadTargets reads the list of targets from a yaml file like:
+//
+//	targets:
+//	- name: prod-dc1
+//	  api: https://api.pks.dc1.example.com
+//	  uaa_cli_id: pks-monitor
+//	  uaa_cli_secret_file: /etc/pks-monitor/secrets/prod-dc1
+//	  ca_cert_file: /etc/pks-monitor/certs/prod-dc1.pem
+//	  tls:
+//	    min_version: "1.2"
+//	  labels:
+//	    datacenter: dc1
+func LoadTargets(path string) ([]Target, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "pks-monitor: couldn't read targets file")
+	}
+
+	var f targetsFile
+	if err := yaml.UnmarshalStrict(buf, &f); err != nil {
+		return nil, errors.Wrapf(err, "pks-monitor: couldn't parse targets file %s", path)
+	}
+
+	if err := ValidateTargets(f.Targets); err != nil {
+		return nil, err
+	}
+	NormalizeLabels(f.Targets)
+	return f.Targets, nil
+}
+
+// ValidateTargets checks that every target is complete and that target names are unique.
+func ValidateTargets(targets []Target) error {
+	if len(targets) == 0 {
+		return errors.New("pks-monitor: no targets configured")
+	}
+
+	names := map[string]bool{}
+	for i, t := range targets {
+		if err := t.Validate(); err != nil {
+			return errors.Wrapf(err, "pks-monitor: invalid target #%d", i+1)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("pks-monitor: duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return nil
+}
+
+// Validate checks that the target has everything needed to monitor it.
+func (t Target) Validate() error {
+	switch {
+	case t.Name == "":
+		return errors.New("name is required")
+	case t.API == "":
+		return fmt.Errorf("%s: api is required", t.Name)
+	case t.UaaCliId == "" || (t.UaaCliSecret == "" && t.UaaCliSecretEnv == "" && t.UaaCliSecretFile == ""):
+		return fmt.Errorf("%s: uaa_cli_id and uaa_cli_secret, uaa_cli_secret_env or uaa_cli_secret_file are required", t.Name)
+	case countSet(t.UaaCliSecret, t.UaaCliSecretEnv, t.UaaCliSecretFile) > 1:
+		return fmt.Errorf("%s: only one of uaa_cli_secret, uaa_cli_secret_env and uaa_cli_secret_file can be set", t.Name)
+	}
+
+	if _, err := endpointURL(t.API, DefaultAPIPort); err != nil {
+		return fmt.Errorf("%s: invalid api: %v", t.Name, err)
+	}
+	if t.UAA != "" {
+		if _, err := endpointURL(t.UAA, DefaultUAAPort); err != nil {
+			return fmt.Errorf("%s: invalid uaa: %v", t.Name, err)
+		}
+	}
+
+	if _, err := t.TLS.minVersion(); err != nil {
+		return fmt.Errorf("%s: invalid tls min_version: %v", t.Name, err)
+	}
+	for _, pin := range t.TLS.PinnedSPKI {
+		if err := pksNet.ValidatePin(pin); err != nil {
+			return fmt.Errorf("%s: invalid tls pinned_spki_sha256: %v", t.Name, err)
+		}
+	}
+	for _, pin := range t.TLS.UAAPinnedSPKI {
+		if err := pksNet.ValidatePin(pin); err != nil {
+			return fmt.Errorf("%s: invalid tls uaa_pinned_spki_sha256: %v", t.Name, err)
+		}
+	}
+
+	for name := range t.Labels {
+		if !labelNameRE.MatchString(name) {
+			return fmt.Errorf("%s: invalid label name %q", t.Name, name)
+		}
+		// prometheus reserves the names starting with __
+		if strings.HasPrefix(name, "__") || contains(reservedLabelNames, name) {
+			return fmt.Errorf("%s: label name %q is reserved", t.Name, name)
+		}
+	}
+	return nil
+}
+
+// resolveEnv reads the UAA client secret from the environment variable the target references.
+func (t *Target) resolveEnv(getenv func(string) string) error {
+	if t.UaaCliSecretEnv == "" {
+		return nil
+	}
+	if t.UaaCliSecret != "" {
+		return fmt.Errorf("%s: only one of uaa_cli_secret and uaa_cli_secret_env can be set", t.Name)
+	}
+	secret := getenv(t.UaaCliSecretEnv)
+	if secret == "" {
+		return fmt.Errorf("%s: environment variable %s of uaa_cli_secret_env is empty", t.Name, t.UaaCliSecretEnv)
+	}
+	t.UaaCliSecret, t.UaaCliSecretEnv = secret, ""
+	return nil
+}
+
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// NormalizeLabels gives every target the same set of label names, setting the
+// missing ones to "". Prometheus requires the metrics of all targets to share
+// the same label names. The targets must have been validated, so their label
+// names don't clash with the ones of the metrics.
+func NormalizeLabels(targets []Target) {
+	names := map[string]bool{}
+	for _, t := range targets {
+		for name := range t.Labels {
+			names[name] = true
+		}
+	}
+
+	for i := range targets {
+		if targets[i].Labels == nil && len(names) > 0 {
+			targets[i].Labels = map[string]string{}
+		}
+		for name := range names {
+			if _, ok := targets[i].Labels[name]; !ok {
+				targets[i].Labels[name] = ""
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	pksNet "github.com/pupimvictor/pks-monitor/net"
+)
+
+func TestLoadTargets(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		wantLabels []map[string]string
+		wantErr    bool
+	}{
+		{
+			name: "ok",
+			file: `
+targets:
+- name: sandbox
+  api: https://api.pks.sandbox.example.com
+  uaa_cli_id: pks-monitor
+  uaa_cli_secret: secret
+- name: prod-dc1
+  api: https://api.pks.dc1.example.com
+  uaa_cli_id: pks-monitor
+  uaa_cli_secret: secret
+  ca_cert_file: /etc/pks-monitor/certs/prod-dc1.pem
+  labels:
+    datacenter: dc1
+`,
+			wantLabels: []map[string]string{
+				{"datacenter": ""},
+				{"datacenter": "dc1"},
+			},
+		},
+		{
+			name: "missing_credentials",
+			file: `
+targets:
+- name: sandbox
+  api: https://api.pks.sandbox.example.com
+`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate_name",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+- {name: sandbox, api: https://b.example.com, uaa_cli_id: id, uaa_cli_secret: secret}
+`,
+			wantErr: true,
+		},
+		{
+			name: "reserved_label",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret, labels: {foundation: x}}
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown_field",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret, port: 9021}
+`,
+			wantErr: true,
+		},
+		{
+			name: "tls",
+			file: `
+targets:
+- name: sandbox
+  api: https://a.example.com
+  uaa_cli_id: id
+  uaa_cli_secret: secret
+  tls: {system_roots: true, server_name: api.pks.example.com, min_version: "1.3", pinned_spki_sha256: [47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=],
+    uaa_server_name: uaa.pks.example.com, uaa_pinned_spki_sha256: [47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=]}
+`,
+			wantLabels: []map[string]string{{}},
+		},
+		{
+			name: "invalid_tls_version",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret, tls: {min_version: "1.4"}}
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid_uaa_pin",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret, tls: {uaa_pinned_spki_sha256: [not-a-hash]}}
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid_pin",
+			file: `
+targets:
+- {name: sandbox, api: https://a.example.com, uaa_cli_id: id, uaa_cli_secret: secret, tls: {pinned_spki_sha256: [not-a-hash]}}
+`,
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			file:    `targets: []`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "targets")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "targets.yml")
+			if err := ioutil.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := LoadTargets(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, want := range tt.wantLabels {
+				if len(got[i].Labels) != len(want) {
+					t.Errorf("LoadTargets() target %s labels = %v, want %v", got[i].Name, got[i].Labels, want)
+					continue
+				}
+				for k, v := range want {
+					if got[i].Labels[k] != v {
+						t.Errorf("LoadTargets() target %s labels = %v, want %v", got[i].Name, got[i].Labels, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTarget_Validate_Labels(t *testing.T) {
+	tests := []struct {
+		label   string
+		wantErr bool
+	}{
+		{"datacenter", false},
+		{"__meta", true},
+	}
+	for _, name := range reservedLabelNames {
+		tests = append(tests, struct {
+			label   string
+			wantErr bool
+		}{name, true})
+	}
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			target := Target{Name: "sandbox", API: "https://a.example.com", UaaCliId: "id", UaaCliSecret: "secret", Labels: map[string]string{tt.label: "x"}}
+			if err := target.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// the labels Validate rejects are the ones the metrics can't be registered with
+			labels := prometheus.Labels{"foundation": target.Name}
+			if tt.label != "foundation" {
+				labels[tt.label] = "x"
+			}
+			err := registerMetrics(labels)
+			if tt.label != "foundation" && (err != nil) != tt.wantErr {
+				t.Errorf("Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// registerMetrics registers the metrics of a foundation labelled with labels and
+// observes a request, which fails on a histogram with an "le" label.
+func registerMetrics(labels prometheus.Labels) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	m := newMetrics(DefaultMetricNamespace, labels)
+	if err := prometheus.NewRegistry().Register(m); err != nil {
+		return err
+	}
+	m.observeTimings("pks_api")(pksNet.Timings{Total: time.Second})
+	return nil
+}
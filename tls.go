@@ -0,0 +1,63 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+
+	pksNet "github.com/pupimvictor/pks-monitor/net"
+)
+
+// certInfo remembers the labels of the last tls_cert_info series of each
+// endpoint, so the series of a rotated certificate can be removed.
+type certInfo struct {
+	mu     sync.Mutex
+	labels map[string][]string
+}
+
+// recordPeerCertificates exports the certificate chain the endpoint presented
+// on a handshake: the leaf expiry, issuer and SANs, and whether the chain
+// verified, or would verify with insecure_skip_verify. It's called when the
+// verification failed as well, with why. With insecure_skip_verify and no CA
+// certificates, there's no telling whether the chain verifies, so the verified
+// gauge is left unset.
+func (pks *PksMonitor) recordPeerCertificates(endpoint string, certs []*x509.Certificate, err error) {
+	if len(certs) == 0 {
+		return
+	}
+	m := pks.metrics
+	leaf := certs[0]
+
+	m.tlsCertExpiry.WithLabelValues(endpoint).Set(float64(leaf.NotAfter.Unix()))
+	m.setCertInfo(endpoint, leaf.Issuer.String(), leaf.Subject.String(), strings.Join(subjectAltNames(leaf), ","))
+
+	if err == pksNet.ErrChainNotVerified {
+		m.tlsCertVerified.DeleteLabelValues(endpoint)
+		return
+	}
+	if err != nil {
+		fmt.Printf("%s: certificate of %s doesn't verify: %v\n", pks.Foundation(), endpoint, err)
+	}
+	m.tlsCertVerified.WithLabelValues(endpoint).Set(boolToFloat(err == nil))
+}
+
+func (m *metrics) setCertInfo(endpoint string, labels ...string) {
+	m.certInfo.mu.Lock()
+	defer m.certInfo.mu.Unlock()
+
+	values := append([]string{endpoint}, labels...)
+	if last, ok := m.certInfo.labels[endpoint]; ok {
+		m.tlsCertInfo.DeleteLabelValues(last...)
+	}
+	m.certInfo.labels[endpoint] = values
+	m.tlsCertInfo.WithLabelValues(values...).Set(1)
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
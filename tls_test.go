@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPksMonitor_recordPeerCertificates(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, clustersResp)
+	}))
+	defer svr.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw}))
+	u, _ := url.Parse(svr.URL)
+	endpoint := map[string]string{"endpoint": u.Host}
+
+	otherCACert := selfSignedCert(t)
+
+	tests := []struct {
+		name         string
+		insecure     bool
+		caCert       string
+		wantUp       bool
+		wantVerified float64
+		// wantNoVerdict is set when the chain can't be verified, without CA
+		wantNoVerdict bool
+	}{
+		{"verified", true, caCert, true, 1, false},
+		{"unknown_ca", true, otherCACert, true, 0, false},
+		{"without_ca", true, "", true, 0, true},
+		{"strict_verified", false, caCert, true, 1, false},
+		// the handshake fails, the certificates are recorded all the same
+		{"strict_unknown_ca", false, otherCACert, false, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pks := newTestMonitor(t, svr.URL, "", "fakeToken")
+			pks.config.SkipSSLVerification = tt.insecure
+			pks.config.CACert = tt.caCert
+			pks.config.apiTransport, pks.config.uaaTransport = nil, nil
+			pks.metrics.tlsInsecure.Set(boolToFloat(tt.insecure))
+			// a chain verified before doesn't stay verified
+			pks.metrics.tlsCertVerified.WithLabelValues(u.Host).Set(1)
+			client, err := pks.newClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pks.client = client
+
+			res := (&apiCheck{pks: pks}).Run(context.Background())
+			if res.Up != tt.wantUp {
+				t.Fatalf("Run() got = %+v, want pks_api up %v", res, tt.wantUp)
+			}
+			if !tt.wantUp && res.Reason != ReasonTLSVerify {
+				t.Errorf("Run() reason = %q, want %q", res.Reason, ReasonTLSVerify)
+			}
+
+			expiry, ok := gaugeValue(t, pks.metrics.tlsCertExpiry, "wf_opp_tls_cert_expiry_seconds", endpoint)
+			if want := float64(svr.Certificate().NotAfter.Unix()); !ok || expiry != want {
+				t.Errorf("wf_opp_tls_cert_expiry_seconds = %v, want %v", expiry, want)
+			}
+			if _, ok := gaugeValue(t, pks.metrics.tlsCertInfo, "wf_opp_tls_cert_info", map[string]string{"endpoint": u.Host, "sans": "example.com,*.example.com,127.0.0.1,::1"}); !ok {
+				t.Errorf("wf_opp_tls_cert_info with SANs not found")
+			}
+			if insecure, ok := gaugeValue(t, pks.metrics.tlsInsecure, "wf_opp_tls_insecure_skip_verify", nil); !ok || insecure != boolToFloat(tt.insecure) {
+				t.Errorf("wf_opp_tls_insecure_skip_verify = %v, want %v", insecure, boolToFloat(tt.insecure))
+			}
+			verified, ok := gaugeValue(t, pks.metrics.tlsCertVerified, "wf_opp_tls_cert_chain_verified", endpoint)
+			if tt.wantNoVerdict {
+				if ok {
+					t.Errorf("wf_opp_tls_cert_chain_verified = %v, want it unset", verified)
+				}
+			} else if !ok || verified != tt.wantVerified {
+				t.Errorf("wf_opp_tls_cert_chain_verified = %v, want %v", verified, tt.wantVerified)
+			}
+		})
+	}
+}
+
+// selfSignedCert returns a PEM encoded CA certificate which didn't sign the certificate of any test server.
+func selfSignedCert(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -0,0 +1,272 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pupimvictor/pks-monitor/uaa"
+)
+
+const (
+	// DefaultRefreshFraction of the token lifetime after which the token is refreshed.
+	DefaultRefreshFraction = 0.8
+
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = time.Minute
+)
+
+// TokenManager gets the access token of a foundation and refreshes it in the
+// background before it expires, so checks don't fail on an expired token.
+type TokenManager struct {
+	// RefreshFraction of the token lifetime after which the token is refreshed.
+	RefreshFraction float64
+	// MinBackoff and MaxBackoff bound the delay between retries of a failed refresh.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	config  *Config
+	metrics *metrics
+
+	mu sync.RWMutex
+	// issued and expiry bound the lifetime of the current token
+	issued  time.Time
+	expiry  time.Time
+	granted time.Time
+	revoked bool
+	// changed is signalled when a token is granted, so Start reschedules the
+	// refresh of a token refreshed elsewhere
+	changed chan struct{}
+}
+
+func newTokenManager(config *Config, m *metrics) *TokenManager {
+	return &TokenManager{
+		RefreshFraction: DefaultRefreshFraction,
+		MinBackoff:      defaultMinBackoff,
+		MaxBackoff:      defaultMaxBackoff,
+		config:          config,
+		metrics:         m,
+		changed:         make(chan struct{}, 1),
+	}
+}
+
+// Refresh gets a new access token from UAA. When the token is already being
+// refreshed, Refresh waits for that refresh instead of granting another token.
+func (tm *TokenManager) Refresh() error {
+	_, err := tm.FetchToken()
+	return err
+}
+
+// FetchToken implements net.TokenSource, refreshing the token when the Pks Api rejected it.
+func (tm *TokenManager) FetchToken() (string, error) {
+	if tm.Revoked() {
+		return "", errors.New("pks-monitor: token was revoked")
+	}
+	return tm.config.tokens.Refresh(tm.grant)
+}
+
+// Renew gets a new access token from UAA and stores it once verify accepted it.
+// Unlike Refresh, it doesn't share a refresh already in flight, which may have
+// started with the previous credentials: it waits for it and grants another token.
+func (tm *TokenManager) Renew(verify func(token string) error) error {
+	if tm.Revoked() {
+		return errors.New("pks-monitor: token was revoked")
+	}
+	_, err := tm.config.tokens.Renew(func() (string, error) {
+		return tm.grantVerified(verify)
+	})
+	return err
+}
+
+// Revoke revokes the access token at UAA, so it doesn't outlive the monitor.
+// Once revoked, the token isn't refreshed anymore. Revoking twice, or before a
+// token was granted, is a no-op.
+func (tm *TokenManager) Revoke() error {
+	tm.mu.Lock()
+	if tm.revoked {
+		tm.mu.Unlock()
+		return nil
+	}
+	tm.revoked = true
+	tm.mu.Unlock()
+
+	// no token was granted, there's nothing to revoke nor to count
+	token := tm.config.GetAccessToken()
+	if token == "" {
+		return nil
+	}
+
+	err := revokeToken(tm.config, token)
+	if err != nil {
+		tm.metrics.tokenRevocations.WithLabelValues("failure").Inc()
+		return errors.Wrap(err, "pks-monitor: couldn't revoke token")
+	}
+	tm.metrics.tokenRevocations.WithLabelValues("success").Inc()
+	tm.config.SetAccessToken("")
+	return nil
+}
+
+// Revoked returns true once Revoke was called.
+func (tm *TokenManager) Revoked() bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.revoked
+}
+
+func revokeToken(c *Config, token string) error {
+	uaaClient, err := CreateUaaClient(c)
+	if err != nil {
+		return err
+	}
+	return uaaClient.RevokeToken(token)
+}
+
+func (tm *TokenManager) grant() (string, error) {
+	return tm.grantVerified(nil)
+}
+
+// grantVerified grants a token, and revokes it rather than returning it when
+// verify, if not nil, rejects it.
+func (tm *TokenManager) grantVerified(verify func(token string) error) (string, error) {
+	token, err := grantToken(tm.config)
+	if err != nil {
+		tm.metrics.tokenRefreshErrors.Inc()
+		return "", err
+	}
+	if verify != nil {
+		if err := verify(token.AccessToken); err != nil {
+			// the token is never used, it shouldn't outlive the monitor either
+			revokeToken(tm.config, token.AccessToken)
+			return "", err
+		}
+	}
+
+	issued, expiry := tokenLifetime(token, time.Now())
+	if !expiry.IsZero() {
+		tm.metrics.tokenExpiry.Set(float64(expiry.Unix()))
+	}
+
+	tm.mu.Lock()
+	tm.issued = issued
+	tm.expiry = expiry
+	tm.granted = time.Now()
+	tm.mu.Unlock()
+
+	select {
+	case tm.changed <- struct{}{}:
+	default:
+	}
+	return token.AccessToken, nil
+}
+
+// tokenLifetime returns when token was issued and when it expires, from its iat
+// and exp claims, or else from its expires_in counted from now. The expiry is
+// zero when UAA didn't tell.
+func tokenLifetime(token uaa.Token, now time.Time) (time.Time, time.Time) {
+	if claims, err := uaa.DecodeClaims(token.AccessToken); err == nil && claims.Iat > 0 && claims.Exp > claims.Iat {
+		return claims.IssuedAt(), claims.ExpiresAt()
+	}
+	if token.ExpiresIn > 0 {
+		return now, now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return now, time.Time{}
+}
+
+// Ready returns why the foundation can't be called with the current token, or
+// nil once a token was granted that neither expired nor was revoked.
+func (tm *TokenManager) Ready(now time.Time) error {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	switch {
+	case tm.revoked:
+		return errors.New("token was revoked")
+	case tm.granted.IsZero():
+		return errors.New("no token granted yet")
+	case !tm.expiry.IsZero() && now.After(tm.expiry):
+		return fmt.Errorf("token expired at %s", tm.expiry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Expiry returns when the current token expires, or the zero time if UAA didn't tell.
+func (tm *TokenManager) Expiry() time.Time {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.expiry
+}
+
+// refreshAt returns when RefreshFraction of the lifetime of the current token
+// has passed, or the zero time when it has no expiry.
+func (tm *TokenManager) refreshAt() time.Time {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.expiry.IsZero() {
+		return time.Time{}
+	}
+	lifetime := tm.expiry.Sub(tm.issued)
+	return tm.issued.Add(time.Duration(float64(lifetime) * tm.RefreshFraction))
+}
+
+// Start refreshes the token once RefreshFraction of its lifetime has passed,
+// retrying with an exponential backoff, until ctx is done. A token refreshed
+// elsewhere, when the Pks Api rejected it or the credentials were reloaded,
+// reschedules the refresh.
+func (tm *TokenManager) Start(ctx context.Context) {
+	for {
+		refreshAt := tm.refreshAt()
+		if refreshAt.IsZero() {
+			fmt.Printf("pks-monitor: %s token has no expiry, not refreshing it in the background\n", tm.config.API)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(refreshAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-tm.changed:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		// the token may have been refreshed as the timer fired
+		if time.Now().Before(tm.refreshAt()) {
+			continue
+		}
+		if !tm.refreshWithBackoff(ctx) {
+			return
+		}
+	}
+}
+
+// refreshWithBackoff retries Refresh until it succeeds. It returns false if ctx
+// is done or the token is revoked first.
+func (tm *TokenManager) refreshWithBackoff(ctx context.Context) bool {
+	backoff := tm.MinBackoff
+	for {
+		if tm.Revoked() {
+			return false
+		}
+		err := tm.Refresh()
+		if err == nil {
+			return true
+		}
+		fmt.Printf("pks-monitor: couldn't refresh %s token, retrying in %s: %v\n", tm.config.API, backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > tm.MaxBackoff {
+			backoff = tm.MaxBackoff
+		}
+	}
+}
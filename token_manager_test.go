@@ -0,0 +1,208 @@
+package monitor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pupimvictor/pks-monitor/uaa"
+)
+
+func TestTokenManager_Start(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+	}{
+		{"refresh", 0},
+		{"retry_with_backoff", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var grants int32
+			authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/oauth/token" {
+					return
+				}
+				n := atomic.AddInt32(&grants, 1)
+				// the first grant always succeeds, the next tt.failures fail
+				if n > 1 && n <= 1+tt.failures {
+					w.WriteHeader(500)
+					fmt.Fprintln(w, `{"error":"server_error"}`)
+					return
+				}
+				fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":1}`, n)
+			}))
+			defer authSvr.Close()
+
+			pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "")
+			tm := pks.Tokens()
+			tm.RefreshFraction = 0.1
+			tm.MinBackoff = 10 * time.Millisecond
+			if err := tm.Refresh(); err != nil {
+				t.Fatalf("Refresh() error = %v", err)
+			}
+			if got := pks.config.GetAccessToken(); got != "token-1" {
+				t.Fatalf("Refresh() got token %v, want token-1", got)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go tm.Start(ctx)
+
+			want := fmt.Sprintf("token-%d", 2+tt.failures)
+			deadline := time.Now().Add(2 * time.Second)
+			for pks.config.GetAccessToken() != want {
+				if time.Now().After(deadline) {
+					t.Fatalf("Start() got token %v, want %v", pks.config.GetAccessToken(), want)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			expiry, _ := gaugeValue(t, pks.metrics.tokenExpiry, "wf_opp_token_expiry_timestamp_seconds", nil)
+			if expiry < float64(time.Now().Unix()) {
+				t.Errorf("wf_opp_token_expiry_timestamp_seconds = %v, want a time in the future", expiry)
+			}
+			errs, _ := counterValue(t, pks.metrics.tokenRefreshErrors, "wf_opp_token_refresh_errors_total", nil)
+			if errs < float64(tt.failures) {
+				t.Errorf("wf_opp_token_refresh_errors_total = %v, want at least %v", errs, tt.failures)
+			}
+		})
+	}
+}
+
+func TestTokenManager_Start_Rescheduled(t *testing.T) {
+	var grants int32
+	authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth/token" {
+			return
+		}
+		// the first token is refreshed after 100ms, the next ones after 30 minutes
+		n := atomic.AddInt32(&grants, 1)
+		expiresIn := 3600
+		if n == 1 {
+			expiresIn = 1
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d}`, n, expiresIn)
+	}))
+	defer authSvr.Close()
+
+	pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "")
+	tm := pks.Tokens()
+	tm.RefreshFraction = 0.1
+	if err := tm.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tm.Start(ctx)
+
+	// refreshed elsewhere, like when the Pks Api rejects the token
+	if err := tm.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if got := atomic.LoadInt32(&grants); got != 2 {
+		t.Errorf("Start() granted %d tokens, want 2: the refresh of the first token wasn't rescheduled", got)
+	}
+}
+
+func TestTokenManager_refreshAt(t *testing.T) {
+	now := time.Unix(1588586400, 0)
+	// a token issued 45 minutes ago, for an hour
+	claims, _ := json.Marshal(map[string]interface{}{"jti": "token-id", "iat": now.Add(-45 * time.Minute).Unix(), "exp": now.Add(15 * time.Minute).Unix()})
+	jwt := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(claims) + "."
+
+	tests := []struct {
+		name  string
+		token uaa.Token
+		want  time.Time
+	}{
+		{"claims", uaa.Token{AccessToken: jwt, ExpiresIn: 900}, now.Add(3 * time.Minute)},
+		{"expires_in", uaa.Token{AccessToken: "opaque", ExpiresIn: 3600}, now.Add(48 * time.Minute)},
+		{"no_expiry", uaa.Token{AccessToken: "opaque"}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := &TokenManager{RefreshFraction: 0.8}
+			tm.issued, tm.expiry = tokenLifetime(tt.token, now)
+			if got := tm.refreshAt(); !got.Equal(tt.want) {
+				t.Errorf("refreshAt() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenManager_Revoke(t *testing.T) {
+	tests := []struct {
+		name       string
+		respCode   int
+		wantErr    bool
+		wantResult string
+	}{
+		{"revoked", 200, false, "success"},
+		{"rejected", 500, true, "failure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked string
+			authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodDelete {
+					revoked = r.URL.Path
+				}
+				w.WriteHeader(tt.respCode)
+			}))
+			defer authSvr.Close()
+
+			// {"alg":"none"}.{"jti":"token-id"}.
+			pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "eyJhbGciOiJub25lIn0.eyJqdGkiOiJ0b2tlbi1pZCJ9.")
+			tm := pks.Tokens()
+
+			if err := tm.Revoke(); (err != nil) != tt.wantErr {
+				t.Fatalf("Revoke() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if revoked != "/oauth/token/revoke/token-id" {
+				t.Errorf("Revoke() revoked %q, want /oauth/token/revoke/token-id", revoked)
+			}
+			if got, _ := counterValue(t, pks, "wf_opp_token_revocations_total", map[string]string{"result": tt.wantResult}); got != 1 {
+				t.Errorf("wf_opp_token_revocations_total{result=%q} = %v, want 1", tt.wantResult, got)
+			}
+
+			// a revoked token is never refreshed, nor revoked again
+			if _, err := tm.FetchToken(); err == nil {
+				t.Errorf("FetchToken() after Revoke() error = nil, want an error")
+			}
+			revoked = ""
+			if err := tm.Revoke(); err != nil || revoked != "" {
+				t.Errorf("second Revoke() error = %v, revoked %q, want a no-op", err, revoked)
+			}
+		})
+	}
+}
+
+func TestTokenManager_Revoke_NoToken(t *testing.T) {
+	var revoked bool
+	authSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revoked = r.Method == http.MethodDelete
+	}))
+	defer authSvr.Close()
+
+	pks := newTestMonitor(t, authSvr.URL, authSvr.URL, "")
+	if err := pks.Tokens().Revoke(); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if revoked {
+		t.Errorf("Revoke() called UAA without a token")
+	}
+	for _, result := range []string{"success", "failure"} {
+		if _, ok := counterValue(t, pks, "wf_opp_token_revocations_total", map[string]string{"result": result}); ok {
+			t.Errorf("wf_opp_token_revocations_total{result=%q} recorded without a token", result)
+		}
+	}
+}
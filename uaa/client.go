@@ -4,12 +4,13 @@ package uaa
 import (
 	"bytes"
 	"encoding/json"
-	"github.com/pkg/errors"
 	"fmt"
+	"github.com/pkg/errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 // Client makes requests to the UAA server at AuthURL
@@ -27,23 +28,110 @@ type Token struct {
 	Scope        string `json:"scope"`
 }
 
-type responseError struct {
+// ResponseError is the error body returned by UAA when a request is rejected.
+type ResponseError struct {
 	Name        string `json:"error"`
 	Description string `json:"error_description"`
 }
 
-func (e *responseError) Error() string {
+// Metadata captures the data returned by the GET /info on a UAA server
+// This fields are not exhaustive and can added to over time.
+// See: https://docs.cloudfoundry.org/api/uaa/version/4.6.0/index.html#server-information
+type Metadata struct {
+	App struct {
+		Version string `json:"version"`
+	} `json:"app"`
+	Links struct {
+		UAA   string `json:"uaa"`
+		Login string `json:"login"`
+	} `json:"links"`
+	ZoneName string `json:"zone_name"`
+	Prompts  struct {
+		Passcode []string `json:"passcode"`
+	} `json:"prompts"`
+}
+
+// PasscodePrompt returns a prompt to tell the user where to get a passcode from.
+// If not present in the metadata (PCF installation don't seem to return it), will attempt to
+// contruct a plausible URL.
+func (md *Metadata) PasscodePrompt() string {
+	// Give default in case server doesn't tell us
+	if len(md.Prompts.Passcode) == 2 && md.Prompts.Passcode[1] != "" {
+		return md.Prompts.Passcode[1]
+	}
+	var loginURL string
+	if md.Links.Login != "" {
+		loginURL = md.Links.Login
+	} else {
+		loginURL = "https://login.system.example.com"
+	}
+	return fmt.Sprintf("One Time Code ( Get one at %s/passcode )", loginURL)
+}
+
+func (e *ResponseError) Error() string {
 	if e.Description == "" {
 		return e.Name
 	}
 	return fmt.Sprintf("%s %s", e.Name, e.Description)
 }
 
+// Healthz checks UAA's /healthz endpoint, which answers "ok" while the server is up.
+func (u *Client) Healthz() error {
+	request, err := http.NewRequest("GET", u.AuthURL.String()+"/healthz", nil)
+	if err != nil {
+		return errors.Wrap(err, "uaa: unable to create healthz request")
+	}
+
+	response, err := u.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return errors.Wrap(err, "uaa: unable to read healthz response")
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("uaa: healthz returned %d", response.StatusCode)
+	}
+	if status := strings.TrimSpace(string(body)); status != "ok" {
+		return fmt.Errorf("uaa: healthz returned %q", status)
+	}
+	return nil
+}
+
+// Metadata requests the server information from UAA's /info endpoint
+func (u *Client) Metadata() (*Metadata, error) {
+	request, err := http.NewRequest("GET", u.AuthURL.String()+"/info", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to create metadata request")
+	}
+
+	request.Header.Add("Accept", "application/json")
+	response, err := u.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("uaa: unable to fetch metadata successfully, code: %d", response.StatusCode)
+	}
+
+	var md Metadata
+	if err := json.NewDecoder(response.Body).Decode(&md); err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to decode metadata")
+	}
+	return &md, nil
+}
+
 // ClientCredentialGrant requests a Token using client_credentials grant type
 func (u *Client) ClientCredentialGrant(clientId, clientSecret string) (Token, error) {
 	values := url.Values{
 		"grant_type":    {"client_credentials"},
-		"response_type": {"Token"},
+		"response_type": {"token"},
 		"client_id":     {clientId},
 		"client_secret": {clientSecret},
 	}
@@ -53,10 +141,56 @@ func (u *Client) ClientCredentialGrant(clientId, clientSecret string) (Token, er
 	return token, err
 }
 
+// PasswordGrant requests an access token and refresh token using password grant type
+func (u *Client) PasswordGrant(clientId, clientSecret, username, password string) (string, string, error) {
+	values := url.Values{
+		"grant_type":    {"password"},
+		"response_type": {"token"},
+		"username":      {username},
+		"password":      {password},
+		"client_id":     {clientId},
+		"client_secret": {clientSecret},
+	}
+
+	token, err := u.tokenGrantRequest(values)
+
+	return token.AccessToken, token.RefreshToken, err
+}
+
+// PasscodeGrant requests an access token and refresh token using passcode grant type
+func (u *Client) PasscodeGrant(clientId, clientSecret, passcode string) (string, string, error) {
+	values := url.Values{
+		"grant_type":    {"password"},
+		"response_type": {"token"},
+		"passcode":      {passcode},
+		"client_id":     {clientId},
+		"client_secret": {clientSecret},
+	}
+
+	token, err := u.tokenGrantRequest(values)
+
+	return token.AccessToken, token.RefreshToken, err
+}
+
+// RefreshTokenGrant requests a new access token and refresh token using refresh_token grant type
+func (u *Client) RefreshTokenGrant(clientId, clientSecret, refreshToken string) (string, string, error) {
+	values := url.Values{
+		"grant_type":    {"refresh_token"},
+		"response_type": {"token"},
+		"client_id":     {clientId},
+		"client_secret": {clientSecret},
+		"refresh_token": {refreshToken},
+	}
+
+	token, err := u.tokenGrantRequest(values)
+
+	return token.AccessToken, token.RefreshToken, err
+}
+
 func (u *Client) tokenGrantRequest(headers url.Values) (Token, error) {
 	var t Token
 
-	request, err := http.NewRequest("POST", u.AuthURL.String() + "/oauth/token", bytes.NewBufferString(headers.Encode()))
+	request, err := http.NewRequest("POST", u.AuthURL.String()+"/oauth/token", bytes.NewBufferString(headers.Encode()))
 	if err != nil {
 		return t, errors.Wrap(err, "uaa: unable to create tokenGrantRequest")
 	}
@@ -80,11 +214,43 @@ func (u *Client) tokenGrantRequest(headers url.Values) (Token, error) {
 		return t, errors.Wrap(err, "uaa: unable to decode token")
 	}
 
-	respErr := responseError{}
+	respErr := ResponseError{}
 
 	if err := decoder.Decode(&respErr); err != nil {
-		return t, errors.Wrapf(err, "code: %s\n", response.StatusCode)
+		return t, errors.Wrapf(err, "code: %d\n", response.StatusCode)
 	}
 
 	return t, &respErr
 }
+
+// RevokeToken revokes the given access token
+func (u *Client) RevokeToken(accessToken string) error {
+	claims, err := DecodeClaims(accessToken)
+	if err != nil {
+		return err
+	}
+	if claims.Jti == "" {
+		return errors.New("uaa: could not parse jti from payload")
+	}
+
+	request, err := http.NewRequest(http.MethodDelete, u.AuthURL.String()+"/oauth/token/revoke/"+claims.Jti, nil)
+	if err != nil {
+		return errors.Wrap(err, "uaa: unable to create revoke request")
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+
+	response, err := u.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return errors.Wrap(err, "uaa: unable to read revoke response")
+		}
+		return fmt.Errorf("uaa: received HTTP %d error while revoking token from auth server: %q", response.StatusCode, body)
+	}
+	return nil
+}
@@ -3,14 +3,21 @@ package uaa_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 
-	. "code.cloudfoundry.org/credhub-cli/credhub/auth/uaa"
+	. "github.com/pupimvictor/pks-monitor/uaa"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
+func serverURL(server *httptest.Server) url.URL {
+	u, err := url.Parse(server.URL)
+	Expect(err).ToNot(HaveOccurred())
+	return *u
+}
+
 var _ = Describe("Client", func() {
 	Context("ClientCredentialGrant()", func() {
 		It("should make a Token grant request", func() {
@@ -19,13 +26,13 @@ var _ = Describe("Client", func() {
 
 				Expect(r.Method).To(Equal(http.MethodPost))
 
-				Expect(r.URL.Path).To(Equal("/oauth/Token"))
+				Expect(r.URL.Path).To(Equal("/oauth/token"))
 
 				Expect(r.Header.Get("Accept")).To(Equal("application/json"))
 				Expect(r.Header.Get("Content-Type")).To(Equal("application/x-www-form-urlencoded"))
 
 				Expect(r.PostForm.Get("grant_type")).To(Equal("client_credentials"))
-				Expect(r.PostForm.Get("response_type")).To(Equal("Token"))
+				Expect(r.PostForm.Get("response_type")).To(Equal("token"))
 
 				Expect(r.PostForm.Get("client_id")).To(Equal("client-id"))
 				Expect(r.PostForm.Get("client_secret")).To(Equal("client-secret"))
@@ -35,14 +42,14 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
-			accessToken, err := client.ClientCredentialGrant("client-id", "client-secret")
+			token, err := client.ClientCredentialGrant("client-id", "client-secret")
 
 			Expect(err).ToNot(HaveOccurred())
-			Expect(accessToken).To(Equal("access-Token"))
+			Expect(token.AccessToken).To(Equal("access-Token"))
 		})
 	})
 
@@ -53,13 +60,13 @@ var _ = Describe("Client", func() {
 
 				Expect(r.Method).To(Equal(http.MethodPost))
 
-				Expect(r.URL.Path).To(Equal("/oauth/Token"))
+				Expect(r.URL.Path).To(Equal("/oauth/token"))
 
 				Expect(r.Header.Get("Accept")).To(Equal("application/json"))
 				Expect(r.Header.Get("Content-Type")).To(Equal("application/x-www-form-urlencoded"))
 
 				Expect(r.PostForm.Get("grant_type")).To(Equal("password"))
-				Expect(r.PostForm.Get("response_type")).To(Equal("Token"))
+				Expect(r.PostForm.Get("response_type")).To(Equal("token"))
 
 				Expect(r.PostForm.Get("username")).To(Equal("username"))
 				Expect(r.PostForm.Get("password")).To(Equal("password"))
@@ -72,7 +79,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -91,13 +98,13 @@ var _ = Describe("Client", func() {
 
 				Expect(r.Method).To(Equal(http.MethodPost))
 
-				Expect(r.URL.Path).To(Equal("/oauth/Token"))
+				Expect(r.URL.Path).To(Equal("/oauth/token"))
 
 				Expect(r.Header.Get("Accept")).To(Equal("application/json"))
 				Expect(r.Header.Get("Content-Type")).To(Equal("application/x-www-form-urlencoded"))
 
 				Expect(r.PostForm.Get("grant_type")).To(Equal("password"))
-				Expect(r.PostForm.Get("response_type")).To(Equal("Token"))
+				Expect(r.PostForm.Get("response_type")).To(Equal("token"))
 
 				Expect(r.PostForm.Get("passcode")).To(Equal("passcode"))
 
@@ -109,7 +116,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -159,7 +166,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -186,7 +193,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -210,7 +217,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -229,13 +236,13 @@ var _ = Describe("Client", func() {
 
 				Expect(r.Method).To(Equal(http.MethodPost))
 
-				Expect(r.URL.Path).To(Equal("/oauth/Token"))
+				Expect(r.URL.Path).To(Equal("/oauth/token"))
 
 				Expect(r.Header.Get("Accept")).To(Equal("application/json"))
 				Expect(r.Header.Get("Content-Type")).To(Equal("application/x-www-form-urlencoded"))
 
 				Expect(r.PostForm.Get("grant_type")).To(Equal("refresh_token"))
-				Expect(r.PostForm.Get("response_type")).To(Equal("Token"))
+				Expect(r.PostForm.Get("response_type")).To(Equal("token"))
 
 				Expect(r.PostForm.Get("client_id")).To(Equal("client-id"))
 				Expect(r.PostForm.Get("client_secret")).To(Equal("client-secret"))
@@ -247,7 +254,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -273,7 +280,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 			err := client.RevokeToken(token)
@@ -281,7 +288,7 @@ var _ = Describe("Client", func() {
 			Expect(err).To(BeNil())
 			Expect(request.Method).To(Equal(http.MethodDelete))
 			Expect(request.Header.Get("Authorization")).To(Equal("Bearer " + token))
-			Expect(request.URL.Path).To(Equal("/oauth/Token/revoke/1"))
+			Expect(request.URL.Path).To(Equal("/oauth/token/revoke/1"))
 		})
 
 		DescribeTable("Token is invallid",
@@ -293,7 +300,7 @@ var _ = Describe("Client", func() {
 				defer uaaServer.Close()
 
 				client := Client{
-					AuthURL: uaaServer.URL,
+					AuthURL: serverURL(uaaServer),
 					Client:  http.DefaultClient,
 				}
 
@@ -310,7 +317,7 @@ var _ = Describe("Client", func() {
 	DescribeTable("unable to create the Token request",
 		func(performAction func(*Client) error) {
 			client := &Client{
-				AuthURL: "127.0.0.1:urlFailingRequestCreation",
+				AuthURL: url.URL{Host: "127.0.0.1:urlFailingRequestCreation"},
 				Client:  http.DefaultClient,
 			}
 
@@ -347,7 +354,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := &Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -381,7 +388,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := &Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -413,7 +420,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := &Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -444,7 +451,7 @@ var _ = Describe("Client", func() {
 			defer uaaServer.Close()
 
 			client := &Client{
-				AuthURL: uaaServer.URL,
+				AuthURL: serverURL(uaaServer),
 				Client:  http.DefaultClient,
 			}
 
@@ -0,0 +1,80 @@
+package uaa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OpenIDConfiguration captures the data returned by GET /.well-known/openid-configuration.
+// See: https://docs.cloudfoundry.org/api/uaa/version/4.6.0/index.html#openid-connect
+type OpenIDConfiguration struct {
+	Issuer        string `json:"issuer"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JwksURI       string `json:"jwks_uri"`
+}
+
+// OpenIDConfiguration requests the OpenID Connect discovery document of UAA
+func (u *Client) OpenIDConfiguration() (*OpenIDConfiguration, error) {
+	request, err := http.NewRequest("GET", u.AuthURL.String()+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to create openid configuration request")
+	}
+
+	request.Header.Add("Accept", "application/json")
+	response, err := u.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("uaa: unable to fetch openid configuration successfully, code: %d", response.StatusCode)
+	}
+
+	var oc OpenIDConfiguration
+	if err := json.NewDecoder(response.Body).Decode(&oc); err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to decode openid configuration")
+	}
+	return &oc, nil
+}
+
+// Discover returns the URL UAA advertises for itself, from the uaa link of /info
+// or else from the token endpoint of /.well-known/openid-configuration. It lets
+// AuthURL point to a login server or a load balancer in front of UAA.
+func (u *Client) Discover() (*url.URL, error) {
+	md, mdErr := u.Metadata()
+	if mdErr == nil && md.Links.UAA != "" {
+		return parseBaseURL(md.Links.UAA)
+	}
+
+	oc, err := u.OpenIDConfiguration()
+	if err != nil {
+		if mdErr != nil {
+			return nil, errors.Wrapf(err, "uaa: unable to discover uaa (info: %v)", mdErr)
+		}
+		return nil, errors.Wrap(err, "uaa: unable to discover uaa")
+	}
+	if oc.TokenEndpoint == "" {
+		return nil, errors.New("uaa: openid configuration has no token endpoint")
+	}
+	return parseBaseURL(strings.TrimSuffix(oc.TokenEndpoint, "/oauth/token"))
+}
+
+func parseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "uaa: invalid discovered url %q", raw)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("uaa: invalid discovered url %q", raw)
+	}
+	return u, nil
+}
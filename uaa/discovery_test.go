@@ -0,0 +1,71 @@
+package uaa_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/pupimvictor/pks-monitor/uaa"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Discovery", func() {
+	Context("Discover()", func() {
+		It("should use the uaa link of /info", func() {
+			uaaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.URL.Path).To(Equal("/info"))
+				w.Write([]byte(`{"links": {"uaa": "https://uaa.pks.example.com:8443/", "login": "https://login.pks.example.com"}}`))
+			}))
+			defer uaaServer.Close()
+
+			client := Client{
+				AuthURL: serverURL(uaaServer),
+				Client:  http.DefaultClient,
+			}
+
+			u, err := client.Discover()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(u.String()).To(Equal("https://uaa.pks.example.com:8443"))
+		})
+
+		It("should fall back to the openid configuration", func() {
+			uaaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/info":
+					w.WriteHeader(http.StatusNotFound)
+				case "/.well-known/openid-configuration":
+					w.Write([]byte(`{"issuer": "https://uaa.pks.example.com:8443/oauth/token", "token_endpoint": "https://uaa.pks.example.com:8443/oauth/token"}`))
+				}
+			}))
+			defer uaaServer.Close()
+
+			client := Client{
+				AuthURL: serverURL(uaaServer),
+				Client:  http.DefaultClient,
+			}
+
+			u, err := client.Discover()
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(u.String()).To(Equal("https://uaa.pks.example.com:8443"))
+		})
+
+		It("should fail when neither endpoint answers", func() {
+			uaaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			}))
+			defer uaaServer.Close()
+
+			client := Client{
+				AuthURL: serverURL(uaaServer),
+				Client:  http.DefaultClient,
+			}
+
+			_, err := client.Discover()
+
+			Expect(err).To(MatchError(ContainSubstring("uaa: unable to discover uaa")))
+		})
+	})
+})
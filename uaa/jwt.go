@@ -0,0 +1,226 @@
+package uaa
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TokenKey is a key UAA signs tokens with, as returned by GET /token_keys.
+// See: https://docs.cloudfoundry.org/api/uaa/version/4.6.0/index.html#token-keys
+type TokenKey struct {
+	Kid   string `json:"kid"`
+	Kty   string `json:"kty"`
+	Alg   string `json:"alg"`
+	Use   string `json:"use"`
+	Value string `json:"value"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+}
+
+// Claims are the claims of an UAA access token the monitor cares about.
+type Claims struct {
+	Jti         string   `json:"jti"`
+	Exp         int64    `json:"exp"`
+	Iat         int64    `json:"iat"`
+	Scope       []string `json:"scope"`
+	Authorities []string `json:"authorities"`
+	ClientID    string   `json:"client_id"`
+	ZoneID      string   `json:"zid"`
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+var signatureHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// ExpiresAt returns when the token expires.
+func (c *Claims) ExpiresAt() time.Time {
+	return time.Unix(c.Exp, 0)
+}
+
+// IssuedAt returns when the token was issued.
+func (c *Claims) IssuedAt() time.Time {
+	return time.Unix(c.Iat, 0)
+}
+
+// HasAnyScope returns true if the token was granted at least one of scopes.
+func (c *Claims) HasAnyScope(scopes ...string) bool {
+	for _, granted := range c.Scope {
+		for _, s := range scopes {
+			if granted == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TokenKeys requests the keys UAA signs tokens with from UAA's /token_keys endpoint
+func (u *Client) TokenKeys() ([]TokenKey, error) {
+	request, err := http.NewRequest("GET", u.AuthURL.String()+"/token_keys", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to create token keys request")
+	}
+
+	request.Header.Add("Accept", "application/json")
+	response, err := u.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("uaa: unable to fetch token keys successfully, code: %d", response.StatusCode)
+	}
+
+	var keys struct {
+		Keys []TokenKey `json:"keys"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&keys); err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to decode token keys")
+	}
+	return keys.Keys, nil
+}
+
+// VerifyToken verifies the signature of token against the keys from /token_keys and returns its claims.
+func (u *Client) VerifyToken(token string) (*Claims, error) {
+	keys, err := u.TokenKeys()
+	if err != nil {
+		return nil, err
+	}
+	return ParseToken(token, keys, time.Now())
+}
+
+// ParseToken verifies the signature of token with one of keys, checks that it
+// hasn't expired at now and returns its claims.
+func ParseToken(token string, keys []TokenKey, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("uaa: token is not a JWT")
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to decode token header")
+	}
+	hash, ok := signatureHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("uaa: unsupported token signing algorithm %q", header.Alg)
+	}
+
+	key, err := findKey(keys, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := decodeBase64URL(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to decode token signature")
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), signature); err != nil {
+		return nil, errors.Wrap(err, "uaa: invalid token signature")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to decode token claims")
+	}
+	if claims.Exp != 0 && !now.Before(claims.ExpiresAt()) {
+		return nil, fmt.Errorf("uaa: token expired at %s", claims.ExpiresAt().UTC().Format(time.RFC3339))
+	}
+	return &claims, nil
+}
+
+// DecodeClaims returns the claims of token without verifying its signature, to
+// read the claims of a token just granted by UAA.
+func DecodeClaims(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) < 2 {
+		return nil, errors.New("uaa: access token missing segments")
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to decode token payload")
+	}
+	return &claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	buf, err := decodeBase64URL(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// decodeBase64URL decodes s with or without padding, as UAA doesn't always strip it.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// findKey returns the RSA key with kid, or the only key when the token doesn't name one.
+func findKey(keys []TokenKey, kid string) (*rsa.PublicKey, error) {
+	for _, k := range keys {
+		if k.Kid == kid || (kid == "" && len(keys) == 1) {
+			return k.publicKey()
+		}
+	}
+	return nil, fmt.Errorf("uaa: no token key with kid %q", kid)
+}
+
+// publicKey returns the RSA key from its modulus and exponent, or from its PEM value.
+func (k TokenKey) publicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "" && k.Kty != "RSA" {
+		return nil, fmt.Errorf("uaa: unsupported token key type %q", k.Kty)
+	}
+
+	if k.N != "" && k.E != "" {
+		n, err := decodeBase64URL(k.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "uaa: unable to decode token key modulus")
+		}
+		e, err := decodeBase64URL(k.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "uaa: unable to decode token key exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	}
+
+	block, _ := pem.Decode([]byte(k.Value))
+	if block == nil {
+		return nil, fmt.Errorf("uaa: token key %q has no public key", k.Kid)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "uaa: unable to parse token key")
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("uaa: token key %q is not an RSA key", k.Kid)
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,202 @@
+package uaa_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/pupimvictor/pks-monitor/uaa"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func signToken(key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		buf, err := json.Marshal(v)
+		Expect(err).ToNot(HaveOccurred())
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).ToNot(HaveOccurred())
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+var _ = Describe("JWT", func() {
+	var (
+		key    *rsa.PrivateKey
+		keys   []TokenKey
+		now    time.Time
+		claims map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+		keys = []TokenKey{{
+			Kid: "key-1",
+			Kty: "RSA",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}
+		now = time.Unix(1500000000, 0)
+		claims = map[string]interface{}{
+			"jti":         "token-id",
+			"exp":         now.Add(time.Hour).Unix(),
+			"iat":         now.Unix(),
+			"scope":       []string{"pks.clusters.admin", "uaa.none"},
+			"authorities": []string{"pks.clusters.admin"},
+			"client_id":   "monitor",
+			"zid":         "uaa",
+		}
+	})
+
+	Context("ParseToken()", func() {
+		It("should verify the token and decode its claims", func() {
+			token := signToken(key, map[string]interface{}{"alg": "RS256", "kid": "key-1"}, claims)
+
+			c, err := ParseToken(token, keys, now)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Jti).To(Equal("token-id"))
+			Expect(c.ExpiresAt()).To(Equal(now.Add(time.Hour)))
+			Expect(c.IssuedAt()).To(Equal(now))
+			Expect(c.Scope).To(Equal([]string{"pks.clusters.admin", "uaa.none"}))
+			Expect(c.Authorities).To(Equal([]string{"pks.clusters.admin"}))
+			Expect(c.ClientID).To(Equal("monitor"))
+			Expect(c.ZoneID).To(Equal("uaa"))
+			Expect(c.HasAnyScope("pks.clusters.manage", "pks.clusters.admin")).To(BeTrue())
+			Expect(c.HasAnyScope("pks.clusters.manage")).To(BeFalse())
+		})
+
+		It("should verify the token with a PEM key", func() {
+			der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			Expect(err).ToNot(HaveOccurred())
+			keys = []TokenKey{{
+				Kid:   "key-1",
+				Value: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			}}
+			token := signToken(key, map[string]interface{}{"alg": "RS256", "kid": "key-1"}, claims)
+
+			_, err = ParseToken(token, keys, now)
+
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should reject a token signed with another key", func() {
+			other, err := rsa.GenerateKey(rand.Reader, 2048)
+			Expect(err).ToNot(HaveOccurred())
+			token := signToken(other, map[string]interface{}{"alg": "RS256", "kid": "key-1"}, claims)
+
+			_, err = ParseToken(token, keys, now)
+
+			Expect(err).To(MatchError(ContainSubstring("invalid token signature")))
+		})
+
+		It("should reject a token signed with an unknown key", func() {
+			token := signToken(key, map[string]interface{}{"alg": "RS256", "kid": "key-2"}, claims)
+
+			_, err := ParseToken(token, keys, now)
+
+			Expect(err).To(MatchError(`uaa: no token key with kid "key-2"`))
+		})
+
+		It("should reject an unsigned token", func() {
+			token := signToken(key, map[string]interface{}{"alg": "none", "kid": "key-1"}, claims)
+
+			_, err := ParseToken(token, keys, now)
+
+			Expect(err).To(MatchError(`uaa: unsupported token signing algorithm "none"`))
+		})
+
+		It("should reject an expired token", func() {
+			token := signToken(key, map[string]interface{}{"alg": "RS256", "kid": "key-1"}, claims)
+
+			_, err := ParseToken(token, keys, now.Add(2*time.Hour))
+
+			Expect(err).To(MatchError(ContainSubstring("uaa: token expired at")))
+		})
+
+		It("should reject a token that isn't a JWT", func() {
+			_, err := ParseToken("opaque-token", keys, now)
+
+			Expect(err).To(MatchError("uaa: token is not a JWT"))
+		})
+	})
+
+	Context("DecodeClaims()", func() {
+		It("should decode the claims without verifying the token", func() {
+			token := signToken(key, map[string]interface{}{"alg": "none"}, claims)
+
+			decoded, err := DecodeClaims(token)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decoded.Jti).To(Equal("token-id"))
+			Expect(decoded.IssuedAt()).To(Equal(now))
+			Expect(decoded.ExpiresAt()).To(Equal(now.Add(time.Hour)))
+		})
+
+		It("should reject a token without payload", func() {
+			_, err := DecodeClaims("opaque-token")
+
+			Expect(err).To(MatchError("uaa: access token missing segments"))
+		})
+	})
+
+	Context("VerifyToken()", func() {
+		It("should verify the token against the keys from /token_keys", func() {
+			uaaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal(http.MethodGet))
+				Expect(r.URL.Path).To(Equal("/token_keys"))
+
+				json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+			}))
+			defer uaaServer.Close()
+
+			authURL, _ := url.Parse(uaaServer.URL)
+			client := Client{
+				AuthURL: *authURL,
+				Client:  http.DefaultClient,
+			}
+			claims["exp"] = time.Now().Add(time.Hour).Unix()
+			token := signToken(key, map[string]interface{}{"alg": "RS256", "kid": "key-1"}, claims)
+
+			c, err := client.VerifyToken(token)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.ClientID).To(Equal("monitor"))
+		})
+
+		It("should fail when /token_keys fails", func() {
+			uaaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer uaaServer.Close()
+
+			authURL, _ := url.Parse(uaaServer.URL)
+			client := Client{
+				AuthURL: *authURL,
+				Client:  http.DefaultClient,
+			}
+
+			_, err := client.VerifyToken("token")
+
+			Expect(err).To(MatchError(fmt.Sprintf("uaa: unable to fetch token keys successfully, code: %d", http.StatusInternalServerError)))
+		})
+	})
+})
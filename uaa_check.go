@@ -0,0 +1,128 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	pksNet "github.com/pupimvictor/pks-monitor/net"
+	"github.com/pupimvictor/pks-monitor/uaa"
+)
+
+// uaaCheck probes the UAA server of the foundation independently of the Pks Api,
+// so an UAA outage can be told apart from a Pks Api outage.
+//
+// UAA is up when both /healthz and /info answer. The check fails as well when a
+// client_credentials token can't be granted. The granted token is revoked right
+// away, so the checks don't pile up live tokens.
+type uaaCheck struct {
+	pks *PksMonitor
+	// probe is set on the copy run by probes, which doesn't update the metrics
+	// of the foundation
+	probe bool
+}
+
+func (c *uaaCheck) Name() string {
+	return "uaa"
+}
+
+func (c *uaaCheck) isolated() Check {
+	return &uaaCheck{pks: c.pks, probe: true}
+}
+
+func (c *uaaCheck) Run(ctx context.Context) Result {
+	start := time.Now()
+
+	uaaClient, err := c.client(ctx)
+	if err != nil {
+		c.setUp(false)
+		return Result{Duration: time.Since(start), Timestamp: start, Err: err}
+	}
+
+	up, err := probeUaa(uaaClient)
+	c.setUp(up)
+	if err != nil {
+		return Result{Duration: time.Since(start), Timestamp: start, Err: err}
+	}
+
+	grantStart := time.Now()
+	token, err := uaaClient.ClientCredentialGrant(c.pks.config.UaaCliId, c.pks.config.GetUaaCliSecret())
+	c.observeGrant(time.Since(grantStart), err)
+	if err != nil {
+		return Result{Duration: time.Since(start), Timestamp: start, Err: errors.Wrap(err, "pks-monitor: unable to grant uaa token")}
+	}
+	duration := time.Since(start)
+
+	// the grant is what's checked, a failed revocation doesn't fail the check
+	if err := uaaClient.RevokeToken(token.AccessToken); err != nil {
+		fmt.Printf("pks-monitor: %s: couldn't revoke the uaa check token: %v\n", c.pks.Foundation(), err)
+	}
+
+	return Result{Up: true, Duration: duration, Timestamp: start}
+}
+
+// client returns the uaa client of the check, bound to the deadline of ctx. The
+// client of a probe has a transport of its own, so neither the request timings
+// nor the certificates are recorded.
+func (c *uaaCheck) client(ctx context.Context) (*uaa.Client, error) {
+	var uaaClient *uaa.Client
+	if c.probe {
+		transport, err := c.pks.config.probeUAATransport()
+		if err != nil {
+			return nil, errors.Wrap(err, "monitor: could not create HTTPClient")
+		}
+		if uaaClient, err = newUaaClient(c.pks.config, transport); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if uaaClient, err = CreateUaaClient(c.pks.config); err != nil {
+			return nil, err
+		}
+		uaaClient.Client.Transport = pksNet.NewTraceTransport(uaaClient.Client.Transport, c.pks.metrics.observeTimings(c.Name()))
+	}
+
+	// the uaa client doesn't take a context, bound its requests to the deadline of the run
+	if deadline, ok := ctx.Deadline(); ok {
+		uaaClient.Client.Timeout = time.Until(deadline)
+	}
+	return uaaClient, nil
+}
+
+// setUp records whether UAA answered, unless probing.
+func (c *uaaCheck) setUp(up bool) {
+	if !c.probe {
+		c.pks.metrics.uaaUp.Set(boolToFloat(up))
+	}
+}
+
+// observeGrant records how long the token grant took and why it failed, unless probing.
+func (c *uaaCheck) observeGrant(duration time.Duration, err error) {
+	if c.probe {
+		return
+	}
+	c.pks.metrics.uaaTokenGrantDuration.Set(duration.Seconds())
+	if err != nil {
+		c.pks.metrics.uaaTokenGrantErrors.WithLabelValues(grantErrorReason(err)).Inc()
+	}
+}
+
+func probeUaa(uaaClient *uaa.Client) (bool, error) {
+	if err := uaaClient.Healthz(); err != nil {
+		return false, errors.Wrap(err, "pks-monitor: uaa healthz failed")
+	}
+	if _, err := uaaClient.Metadata(); err != nil {
+		return false, errors.Wrap(err, "pks-monitor: uaa info failed")
+	}
+	return true, nil
+}
+
+// grantErrorReason returns the OAuth error UAA rejected the grant with, like
+// "unauthorized" or "invalid_client", or "request_failed" when UAA didn't answer.
+func grantErrorReason(err error) string {
+	if respErr, ok := errors.Cause(err).(*uaa.ResponseError); ok && respErr.Name != "" {
+		return respErr.Name
+	}
+	return "request_failed"
+}
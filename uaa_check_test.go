@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUaaCheck_Run(t *testing.T) {
+	tests := []struct {
+		name        string
+		healthz     string
+		tokenCode   int
+		tokenResp   string
+		want        bool
+		wantUaaUp   float64
+		wantReasons map[string]float64
+		wantRevoked string
+	}{
+		{
+			name:      "ok",
+			healthz:   "ok",
+			tokenCode: 200,
+			// {"alg":"none"}.{"jti":"token-id"}.
+			tokenResp:   `{"access_token":"eyJhbGciOiJub25lIn0.eyJqdGkiOiJ0b2tlbi1pZCJ9."}`,
+			want:        true,
+			wantUaaUp:   1,
+			wantRevoked: "/oauth/token/revoke/token-id",
+		},
+		{
+			name:      "revoke_failed",
+			healthz:   "ok",
+			tokenCode: 200,
+			tokenResp: `{"access_token":"fakeToken"}`,
+			want:      true,
+			wantUaaUp: 1,
+		},
+		{
+			name:      "uaa_down",
+			healthz:   "down",
+			tokenCode: 200,
+			tokenResp: `{"access_token":"fakeToken"}`,
+			want:      false,
+			wantUaaUp: 0,
+		},
+		{
+			name:        "grant_rejected",
+			healthz:     "ok",
+			tokenCode:   401,
+			tokenResp:   `{"error":"unauthorized","error_description":"Bad credentials"}`,
+			want:        false,
+			wantUaaUp:   1,
+			wantReasons: map[string]float64{"unauthorized": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var revoked string
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/healthz":
+					fmt.Fprintln(w, tt.healthz)
+				case "/info":
+					fmt.Fprintln(w, `{"app":{"version":"4.30.0"},"zone_name":"uaa"}`)
+				case "/oauth/token":
+					w.WriteHeader(tt.tokenCode)
+					fmt.Fprintln(w, tt.tokenResp)
+				case "/oauth/token/revoke/token-id":
+					revoked = r.URL.Path
+				default:
+					w.WriteHeader(404)
+				}
+			}))
+			defer svr.Close()
+
+			pks := newTestMonitor(t, svr.URL, svr.URL, "")
+			res := (&uaaCheck{pks: pks}).Run(context.Background())
+			if res.Up != tt.want {
+				t.Errorf("Run() got = %+v, want up %v", res, tt.want)
+			}
+			if got, _ := gaugeValue(t, pks.metrics.uaaUp, "wf_opp_uaa_up", nil); got != tt.wantUaaUp {
+				t.Errorf("wf_opp_uaa_up = %v, want %v", got, tt.wantUaaUp)
+			}
+			if revoked != tt.wantRevoked {
+				t.Errorf("Run() revoked %q, want %q", revoked, tt.wantRevoked)
+			}
+			for reason, want := range tt.wantReasons {
+				got, _ := counterValue(t, pks.metrics.uaaTokenGrantErrors, "wf_opp_uaa_token_grant_errors_total", map[string]string{"reason": reason})
+				if got != want {
+					t.Errorf("wf_opp_uaa_token_grant_errors_total{reason=%q} = %v, want %v", reason, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Defaults of the Wavefront exporter.
+const (
+	DefaultWavefrontFlushInterval = 30 * time.Second
+	DefaultWavefrontBatchSize     = 500
+
+	wavefrontMinBackoff = time.Second
+	wavefrontMaxBackoff = time.Minute
+)
+
+var wavefrontTagRE = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// WavefrontConfig configures the export of the metrics to a Wavefront proxy,
+// which is enabled by setting Proxy:
+//
+//	wavefront:
+//	  proxy: wavefront-proxy.monitoring:2878
+//	  source: pks-monitor
+//	  tags:
+//	    env: prod
+//	  flush_interval: 30s
+//	  batch_size: 500
+//
+// Source defaults to the hostname. Tags are added to every point, along with
+// the labels of the metric.
+type WavefrontConfig struct {
+	Proxy         string            `yaml:"proxy"`
+	Source        string            `yaml:"source"`
+	Tags          map[string]string `yaml:"tags"`
+	FlushInterval time.Duration     `yaml:"flush_interval"`
+	BatchSize     int               `yaml:"batch_size"`
+}
+
+// Validate checks the configuration of an enabled exporter.
+func (c WavefrontConfig) Validate() error {
+	if c.Proxy == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Proxy); err != nil {
+		return fmt.Errorf("pks-monitor: wavefront proxy must be a host:port, got %q", c.Proxy)
+	}
+	if c.FlushInterval < time.Second {
+		return durationError("wavefront flush_interval", c.FlushInterval)
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("pks-monitor: wavefront batch_size must be at least 1, got %d", c.BatchSize)
+	}
+	for name := range c.Tags {
+		if !wavefrontTagRE.MatchString(name) {
+			return fmt.Errorf("pks-monitor: invalid wavefront tag name %q", name)
+		}
+	}
+	return nil
+}
+
+// WavefrontExporter sends the metrics of a prometheus.Gatherer to a Wavefront
+// proxy, in its line format, over TCP. The points are sent in batches of
+// BatchSize lines. When the proxy can't be reached, the exporter reconnects with
+// an exponential backoff.
+type WavefrontExporter struct {
+	config   WavefrontConfig
+	gatherer prometheus.Gatherer
+	dial     func(ctx context.Context) (net.Conn, error)
+
+	// mu serializes the flushes, and guards the connection and its backoff
+	mu      sync.Mutex
+	conn    net.Conn
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// NewWavefrontExporter returns an exporter sending the metrics of gatherer to the proxy of config.
+func NewWavefrontExporter(config WavefrontConfig, gatherer prometheus.Gatherer) *WavefrontExporter {
+	if config.Source == "" {
+		config.Source, _ = os.Hostname()
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultWavefrontFlushInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultWavefrontBatchSize
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	return &WavefrontExporter{
+		config:   config,
+		gatherer: gatherer,
+		dial: func(ctx context.Context) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", config.Proxy)
+		},
+	}
+}
+
+// Run flushes the metrics every FlushInterval until ctx is done.
+func (e *WavefrontExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		flushCtx, cancel := context.WithTimeout(ctx, e.config.FlushInterval)
+		if err := e.Flush(flushCtx); err != nil {
+			fmt.Printf("pks-monitor: couldn't send metrics to wavefront: %v\n", err)
+		}
+		cancel()
+	}
+}
+
+// Flush gathers the metrics and sends them to the proxy. It retries a batch
+// the proxy didn't take until ctx is done, the remaining points are dropped.
+func (e *WavefrontExporter) Flush(ctx context.Context) error {
+	mfs, err := e.gatherer.Gather()
+	if err != nil && len(mfs) == 0 {
+		return errors.Wrap(err, "pks-monitor: couldn't gather metrics")
+	}
+	lines := wavefrontLines(mfs, e.config.Source, e.config.Tags, time.Now())
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for len(lines) > 0 {
+		n := e.config.BatchSize
+		if n > len(lines) {
+			n = len(lines)
+		}
+		if err := e.send(ctx, strings.Join(lines[:n], "")); err != nil {
+			return errors.Wrapf(err, "pks-monitor: dropped %d points", len(lines))
+		}
+		lines = lines[n:]
+	}
+	return nil
+}
+
+// send writes batch to the proxy, reconnecting until it's written or ctx is done.
+func (e *WavefrontExporter) send(ctx context.Context, batch string) error {
+	for {
+		if err := e.connect(ctx); err != nil {
+			return err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = e.conn.SetWriteDeadline(deadline)
+		}
+		_, err := e.conn.Write([]byte(batch))
+		if err == nil {
+			return nil
+		}
+		fmt.Printf("pks-monitor: wavefront proxy %s write failed, reconnecting: %v\n", e.config.Proxy, err)
+		e.closeConn()
+		e.failed()
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// connect dials the proxy when there's no connection, waiting for the backoff
+// of the last failure first.
+func (e *WavefrontExporter) connect(ctx context.Context) error {
+	for e.conn == nil {
+		if wait := time.Until(e.retryAt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.Wrapf(ctx.Err(), "wavefront proxy %s unreachable", e.config.Proxy)
+			case <-timer.C:
+			}
+		}
+
+		conn, err := e.dial(ctx)
+		if err != nil {
+			e.failed()
+			fmt.Printf("pks-monitor: couldn't connect to wavefront proxy %s, retrying in %s: %v\n", e.config.Proxy, e.backoff, err)
+			continue
+		}
+		e.conn = conn
+		e.backoff = 0
+		e.retryAt = time.Time{}
+	}
+	return nil
+}
+
+// failed doubles the backoff before the next connection attempt.
+func (e *WavefrontExporter) failed() {
+	e.backoff *= 2
+	if e.backoff < wavefrontMinBackoff {
+		e.backoff = wavefrontMinBackoff
+	}
+	if e.backoff > wavefrontMaxBackoff {
+		e.backoff = wavefrontMaxBackoff
+	}
+	e.retryAt = time.Now().Add(e.backoff)
+}
+
+func (e *WavefrontExporter) closeConn() {
+	if e.conn != nil {
+		_ = e.conn.Close()
+		e.conn = nil
+	}
+}
+
+// Close closes the connection to the proxy.
+func (e *WavefrontExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closeConn()
+	return nil
+}
+
+// wavefrontLines formats the metrics as Wavefront points, one line each:
+//
+//	"wf_opp_check_up" 1 1588586400 source="pks-monitor" "check"="pks_api" "foundation"="prod-dc1"
+//
+// Histograms and summaries are sent as their _bucket, _sum and _count series,
+// like Prometheus exposes them. NaN and infinite values aren't sent.
+func wavefrontLines(mfs []*dto.MetricFamily, source string, tags map[string]string, now time.Time) []string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	var lines []string
+	add := func(name string, value float64, labels map[string]string) {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+		var b strings.Builder
+		b.WriteString(wavefrontQuote(name))
+		b.WriteString(" ")
+		b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		b.WriteString(" ")
+		b.WriteString(timestamp)
+		b.WriteString(" source=")
+		b.WriteString(wavefrontQuote(source))
+
+		names := make([]string, 0, len(labels))
+		for k := range labels {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			if labels[k] == "" {
+				continue
+			}
+			b.WriteString(" ")
+			b.WriteString(wavefrontQuote(k))
+			b.WriteString("=")
+			b.WriteString(wavefrontQuote(labels[k]))
+		}
+		b.WriteString("\n")
+		lines = append(lines, b.String())
+	}
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for k, v := range tags {
+				labels[k] = v
+			}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue(), labels)
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue(), labels)
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue(), labels)
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					add(name+"_bucket", float64(bucket.GetCumulativeCount()), withLabel(labels, "le", strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)))
+				}
+				add(name+"_bucket", float64(h.GetSampleCount()), withLabel(labels, "le", "+Inf"))
+				add(name+"_sum", h.GetSampleSum(), labels)
+				add(name+"_count", float64(h.GetSampleCount()), labels)
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), withLabel(labels, "quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)))
+				}
+				add(name+"_sum", s.GetSampleSum(), labels)
+				add(name+"_count", float64(s.GetSampleCount()), labels)
+			}
+		}
+	}
+	return lines
+}
+
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	l := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		l[k] = v
+	}
+	l[name] = value
+	return l
+}
+
+// wavefrontQuote quotes a metric name, source or tag of the Wavefront line format.
+func wavefrontQuote(s string) string {
+	s = strings.NewReplacer(`"`, `\"`, "\n", " ").Replace(s)
+	return `"` + s + `"`
+}
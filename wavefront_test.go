@@ -0,0 +1,172 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWavefrontLines(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "wf_opp_check_up", Help: "up"}, []string{"check", "datacenter"})
+	up.WithLabelValues("pks_api", "").Set(1)
+	up.WithLabelValues(`say "hi"`, "dc1").Set(0)
+	errs := prometheus.NewCounter(prometheus.CounterOpts{Name: "wf_opp_check_errors_total", Help: "errors"})
+	errs.Add(3)
+	phase := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "wf_opp_http_phase_duration_seconds", Help: "phase", Buckets: []float64{0.5}})
+	phase.Observe(0.2)
+	reg.MustRegister(up, errs, phase)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := wavefrontLines(mfs, "pks-monitor", map[string]string{"env": "prod"}, time.Unix(1588586400, 0))
+
+	want := []string{
+		`"wf_opp_check_errors_total" 3 1588586400 source="pks-monitor" "env"="prod"` + "\n",
+		`"wf_opp_check_up" 1 1588586400 source="pks-monitor" "check"="pks_api" "env"="prod"` + "\n",
+		`"wf_opp_check_up" 0 1588586400 source="pks-monitor" "check"="say \"hi\"" "datacenter"="dc1" "env"="prod"` + "\n",
+		`"wf_opp_http_phase_duration_seconds_bucket" 1 1588586400 source="pks-monitor" "env"="prod" "le"="0.5"` + "\n",
+		`"wf_opp_http_phase_duration_seconds_bucket" 1 1588586400 source="pks-monitor" "env"="prod" "le"="+Inf"` + "\n",
+		`"wf_opp_http_phase_duration_seconds_sum" 0.2 1588586400 source="pks-monitor" "env"="prod"` + "\n",
+		`"wf_opp_http_phase_duration_seconds_count" 1 1588586400 source="pks-monitor" "env"="prod"` + "\n",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("wavefrontLines() got %d lines, want %d:\n%s", len(lines), len(want), strings.Join(lines, ""))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("wavefrontLines()[%d] = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
+
+// proxy is a local Wavefront proxy collecting the lines it receives.
+type proxy struct {
+	listener net.Listener
+	lines    chan string
+}
+
+func newProxy(t *testing.T) *proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{listener: l, lines: make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					p.lines <- scanner.Text()
+				}
+			}(conn)
+		}
+	}()
+	return p
+}
+
+func (p *proxy) next(t *testing.T) string {
+	select {
+	case line := <-p.lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy received no line")
+		return ""
+	}
+}
+
+func testGatherer() prometheus.Gatherer {
+	reg := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "wf_opp_check_up", Help: "up"}, []string{"check"})
+	up.WithLabelValues("pks_api").Set(1)
+	up.WithLabelValues("uaa").Set(0)
+	reg.MustRegister(up)
+	return reg
+}
+
+func TestWavefrontExporter_Flush(t *testing.T) {
+	p := newProxy(t)
+	defer p.listener.Close()
+
+	e := NewWavefrontExporter(WavefrontConfig{
+		Proxy:     p.listener.Addr().String(),
+		Source:    "pks-monitor",
+		Tags:      map[string]string{"env": "prod"},
+		BatchSize: 1,
+	}, testGatherer())
+	defer e.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	for _, check := range []string{"pks_api", "uaa"} {
+		line := p.next(t)
+		if !strings.HasPrefix(line, `"wf_opp_check_up" `) || !strings.HasSuffix(line, `source="pks-monitor" "check"="`+check+`" "env"="prod"`) {
+			t.Errorf("proxy got %q, want the wf_opp_check_up point of %s", line, check)
+		}
+	}
+}
+
+func TestWavefrontExporter_Reconnect(t *testing.T) {
+	p := newProxy(t)
+	defer p.listener.Close()
+
+	e := NewWavefrontExporter(WavefrontConfig{Proxy: p.listener.Addr().String(), Source: "pks-monitor"}, testGatherer())
+	defer e.Close()
+	dial := e.dial
+	var dials int
+	e.dial = func(ctx context.Context) (net.Conn, error) {
+		dials++
+		switch dials {
+		case 1:
+			return nil, errors.New("connection refused")
+		case 2:
+			// the proxy closes the connection, the write fails
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		}
+		return dial(ctx)
+	}
+
+	// the proxy is down, the points are dropped and the next attempt backs off
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := e.Flush(ctx); err == nil {
+		t.Fatal("Flush() with the proxy down, want error")
+	}
+	if e.backoff != wavefrontMinBackoff || !e.retryAt.After(time.Now()) {
+		t.Errorf("Flush() backoff = %s, retry at %s, want %s from now", e.backoff, e.retryAt, wavefrontMinBackoff)
+	}
+
+	// the proxy is back, but drops the first connection
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if dials != 3 {
+		t.Errorf("Flush() dialed %d times, want 3", dials)
+	}
+	if line := p.next(t); !strings.HasPrefix(line, `"wf_opp_check_up" `) {
+		t.Errorf("proxy got %q, want a wf_opp_check_up point", line)
+	}
+	if e.backoff != 0 {
+		t.Errorf("Flush() backoff = %s after reconnecting, want 0", e.backoff)
+	}
+}